@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/coreos/go-systemd/activation"
+)
+
+// activationSockets returns the UDP and TCP sockets passed in by systemd
+// socket activation via LISTEN_FDS, if any.
+func activationSockets() ([]net.PacketConn, []net.Listener, error) {
+	var conns []net.PacketConn
+	var listeners []net.Listener
+
+	for _, f := range activation.Files(true) {
+		// net duplicates the descriptor, so the original is closed either way
+		listener, err := net.FileListener(f)
+		if err == nil {
+			listeners = append(listeners, listener)
+			f.Close()
+			continue
+		}
+
+		conn, err := net.FilePacketConn(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("unsupported socket passed by systemd: %s", f.Name())
+		}
+		conns = append(conns, conn)
+	}
+
+	return conns, listeners, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// splitList splits a comma-separated list, ignoring empty entries.
+func splitList(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// interfaceAddresses returns the addresses of the named interfaces, in the
+// order given, or of all interfaces if no names are given.
+func interfaceAddresses(names []string) ([]net.IP, error) {
+	var addrs []net.Addr
+
+	if len(names) == 0 {
+		var err error
+		if addrs, err = net.InterfaceAddrs(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range names {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("interface %s: %s", name, err)
+		}
+		ifaceAddrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("interface %s: %s", name, err)
+		}
+		addrs = append(addrs, ifaceAddrs...)
+	}
+
+	var ips []net.IP
+	for _, address := range addrs {
+		if ipnet, ok := address.(*net.IPNet); ok && !ipnet.IP.IsMulticast() {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	return ips, nil
+}
+
+// ipAddress picks the address to advertise to the host from the named
+// interfaces, or from all interfaces if none are named. IPv4 addresses are
+// preferred, falling back to global IPv6 addresses.
+func ipAddress(interfaces []string) (string, error) {
+	addrs, err := interfaceAddresses(interfaces)
+	if err != nil {
+		return "", err
+	}
+
+	var fallback net.IP
+
+	for _, ip := range addrs {
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipv4 := ip.To4(); ipv4 != nil {
+			return ipv4.String(), nil
+		}
+		if fallback == nil {
+			fallback = ip
+		}
+	}
+
+	if fallback != nil {
+		return fallback.String(), nil
+	}
+	return "", errors.New("no addresses found")
+}
+
+// listenAddresses returns the addresses to bind the DNS server to, from
+// LISTEN_ADDRS and LISTEN_INTERFACES. No addresses means binding to all IPv4
+// addresses.
+func listenAddresses(o *options) ([]net.IP, error) {
+	var addrs []net.IP
+
+	for _, addr := range o.listenAddrs {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("LISTEN_ADDRS: invalid address %q", addr)
+		}
+		addrs = append(addrs, ip)
+	}
+
+	if interfaces := o.listenInterfaces; len(interfaces) > 0 {
+		ifaceAddrs, err := interfaceAddresses(interfaces)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ifaceAddrs {
+			// binding link-local addresses requires a zone
+			if !ip.IsLinkLocalUnicast() {
+				addrs = append(addrs, ip)
+			}
+		}
+		if len(ifaceAddrs) == 0 {
+			return nil, fmt.Errorf("LISTEN_INTERFACES: no addresses found on %s", strings.Join(interfaces, ", "))
+		}
+	}
+
+	return addrs, nil
+}
+
+// advertiseAddress returns the address stored in the host resolver configs:
+// ADVERTISE_ADDR if set, otherwise picked from ADVERTISE_INTERFACES, then
+// from the addresses the server is bound to, then from all interfaces.
+func advertiseAddress(o *options, listen []net.IP) (string, error) {
+	if addr := o.advertiseAddr; addr != "" {
+		if net.ParseIP(addr) == nil {
+			return "", fmt.Errorf("ADVERTISE_ADDR: invalid address %q", addr)
+		}
+		return addr, nil
+	}
+
+	if interfaces := o.advertiseInterfaces; len(interfaces) > 0 {
+		return ipAddress(interfaces)
+	}
+
+	for _, ip := range listen {
+		if !ip.IsLoopback() && !ip.IsUnspecified() {
+			return ip.String(), nil
+		}
+	}
+
+	return ipAddress(nil)
+}
+
+// addressSettle waits for a burst of address changes, like a DHCP renewal
+// removing and adding addresses, to finish before picking a new address.
+const addressSettle = time.Second
+
+// addressFollower stops following address changes when closed.
+type addressFollower struct {
+	watcher io.Closer
+	stop    chan struct{}
+}
+
+func (f *addressFollower) Close() error {
+	close(f.stop)
+	return f.watcher.Close()
+}
+
+// followAddress re-runs the address selection whenever the host's addresses
+// change, and calls onChange when a different address is picked, until the
+// returned follower is closed.
+func followAddress(o *options, listen []net.IP, address string, onChange func(string)) (*addressFollower, error) {
+	return followAddressChanges(func() {
+		current, err := advertiseAddress(o, listen)
+		if err != nil {
+			log.Println("error picking local address:", err)
+			return
+		}
+		if current != address {
+			address = current
+			onChange(address)
+		}
+	})
+}
+
+// followAddressChanges calls changed once the host's addresses settle after
+// each change, until the returned follower is closed.
+func followAddressChanges(changed func()) (*addressFollower, error) {
+	changes := make(chan struct{}, 1)
+	watcher, err := watchAddresses(func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	follower := &addressFollower{watcher: watcher, stop: make(chan struct{})}
+
+	go func() {
+		var settled <-chan time.Time
+		for {
+			select {
+			case <-follower.stop:
+				return
+			case <-changes:
+				settled = time.After(addressSettle)
+				continue
+			case <-settled:
+				settled = nil
+			}
+			changed()
+		}
+	}()
+
+	return follower, nil
+}
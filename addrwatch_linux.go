@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"syscall"
+)
+
+// watchAddresses calls changed whenever an address is added to or removed
+// from a network interface, until the returned closer is closed.
+func watchAddresses(changed func()) (io.Closer, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+
+	addr := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		// the RTMGRP_* bitmask for the RTNLGRP_* multicast groups
+		Groups: 1<<(syscall.RTNLGRP_IPV4_IFADDR-1) | 1<<(syscall.RTNLGRP_IPV6_IFADDR-1),
+	}
+	if err = syscall.Bind(fd, addr); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	// a non-blocking file uses the runtime poller, so closing it stops a read
+	conn := os.NewFile(uintptr(fd), "netlink")
+
+	go func() {
+		buf := make([]byte, os.Getpagesize())
+		for {
+			n, err := conn.Read(buf)
+			if errors.Is(err, syscall.ENOBUFS) {
+				// messages were dropped, which may have included changes
+				changed()
+				continue
+			}
+			if err != nil {
+				if !errors.Is(err, os.ErrClosed) {
+					log.Println("error watching addresses:", err)
+				}
+				return
+			}
+
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				log.Println("error watching addresses:", err)
+				continue
+			}
+			for _, msg := range msgs {
+				if msg.Header.Type == syscall.RTM_NEWADDR || msg.Header.Type == syscall.RTM_DELADDR {
+					changed()
+					break
+				}
+			}
+		}
+	}()
+
+	return conn, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+func watchAddresses(changed func()) (io.Closer, error) {
+	return nil, errors.New("watching addresses is only supported on Linux")
+}
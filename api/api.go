@@ -0,0 +1,340 @@
+// Package api serves a JSON HTTP API to list, add and remove the hosts and
+// upstream servers of a running resolvable, for example to register fake
+// services in tests without starting containers. It has no authentication,
+// so it only listens on Unix sockets and loopback addresses.
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gliderlabs/resolvable/resolver"
+	"github.com/miekg/dns"
+)
+
+// ManualPrefix prefixes the ids of the hosts and upstreams added through the
+// API. Only those can be removed through it.
+const ManualPrefix = "api:"
+
+// The sources of the hosts and upstreams, derived from their ids.
+const (
+	SourceDocker  = "docker"
+	SourceManual  = "manual"
+	SourceStatic  = "static"
+	SourceConfig  = "config"
+	SourceDynamic = "dynamic"
+)
+
+// Source returns where the host or upstream with the given id came from.
+func Source(id string) string {
+	switch {
+	case strings.HasPrefix(id, ManualPrefix):
+		return SourceManual
+	case strings.HasPrefix(id, "static:"):
+		return SourceStatic
+	case strings.HasPrefix(id, "upstream:"), strings.HasPrefix(id, "resolv.conf:"):
+		return SourceConfig
+	case strings.HasPrefix(id, "update:"):
+		return SourceDynamic
+	default:
+		// containers and the Docker bridges
+		return SourceDocker
+	}
+}
+
+// Resolver is the resolver the API updates and lists.
+type Resolver interface {
+	AddHost(id string, addr net.IP, name string, aliases ...string) error
+	RemoveHost(id string) error
+	AddUpstream(id string, addr net.IP, port int, domains ...string) error
+	RemoveUpstream(id string) error
+	Records() []resolver.HostRecord
+	Upstreams() []resolver.UpstreamRecord
+}
+
+// Host is a host as listed, and added, through the API.
+type Host struct {
+	ID      string   `json:"id"`
+	Address string   `json:"address"`
+	Names   []string `json:"names"`
+	Source  string   `json:"source"`
+}
+
+// Upstream is an upstream server as listed, and added, through the API.
+type Upstream struct {
+	ID      string   `json:"id"`
+	Address string   `json:"address"`
+	Port    int      `json:"port"`
+	Domains []string `json:"domains"`
+	Source  string   `json:"source"`
+}
+
+// Server serves the API for a resolver.
+type Server struct {
+	resolver Resolver
+	listener net.Listener
+	server   *http.Server
+}
+
+// NewServer returns an API server for r, to serve with ServeHTTP or Listen.
+func NewServer(r Resolver) *Server {
+	s := &Server{resolver: r}
+	s.server = &http.Server{Handler: s}
+	return s
+}
+
+// Listen serves the API on addr, either unix:PATH for a Unix socket or
+// HOST:PORT with a loopback address, until Close is called.
+func (s *Server) Listen(addr string) error {
+	network, address, err := ParseAddress(addr)
+	if err != nil {
+		return err
+	}
+	if network == "unix" {
+		if err := removeSocket(address); err != nil {
+			return err
+		}
+	}
+	s.listener, err = net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	log.Println("api: listening on", addr)
+
+	go func() {
+		if err := s.server.Serve(s.listener); err != nil && err != http.ErrServerClosed {
+			log.Println("api:", err)
+		}
+	}()
+	return nil
+}
+
+// Close stops serving the API, which removes its Unix socket.
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
+// removeSocket removes the socket left at path by a previous run, refusing to
+// remove any other kind of file.
+func removeSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	return os.Remove(path)
+}
+
+// ParseAddress returns the network and address to listen on for addr, which
+// must be unix:PATH or HOST:PORT with a loopback address.
+func ParseAddress(addr string) (network, address string, err error) {
+	if path := strings.TrimPrefix(addr, "unix:"); path != addr {
+		if path == "" {
+			return "", "", errors.New("missing socket path")
+		}
+		return "unix", path, nil
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return "", "", fmt.Errorf("%s is not a loopback address, the API has no authentication", host)
+	}
+	return "tcp", addr, nil
+}
+
+// ServeHTTP serves the API. As it has no authentication, requests for other
+// hosts than localhost are refused, so web pages can't reach it through DNS
+// rebinding, and so are bodies other than JSON, which web pages can't send
+// without the browser asking first.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !loopbackHost(req.Host) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("host %q is not localhost", req.Host))
+		return
+	}
+	if req.Method == "POST" {
+		if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType != "application/json" {
+			writeError(w, http.StatusUnsupportedMediaType, errors.New("expected Content-Type application/json"))
+			return
+		}
+	}
+
+	path := strings.Trim(req.URL.Path, "/")
+	collection, id := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		collection, id = path[:i], path[i+1:]
+	}
+
+	switch {
+	case collection == "hosts" && id == "" && req.Method == "GET":
+		s.listHosts(w)
+	case collection == "hosts" && id == "" && req.Method == "POST":
+		s.addHost(w, req)
+	case collection == "hosts" && id != "" && req.Method == "DELETE":
+		s.remove(w, id, s.resolver.RemoveHost)
+	case collection == "upstreams" && id == "" && req.Method == "GET":
+		s.listUpstreams(w)
+	case collection == "upstreams" && id == "" && req.Method == "POST":
+		s.addUpstream(w, req)
+	case collection == "upstreams" && id != "" && req.Method == "DELETE":
+		s.remove(w, id, s.resolver.RemoveUpstream)
+	case collection == "hosts" || collection == "upstreams":
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+// loopbackHost returns whether the Host header of a request is localhost or a
+// loopback address, with or without a port.
+func loopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *Server) listHosts(w http.ResponseWriter) {
+	hosts := []Host{}
+	for _, record := range s.resolver.Records() {
+		hosts = append(hosts, Host{ID: record.ID, Address: record.Address, Names: record.Names, Source: Source(record.ID)})
+	}
+	writeJSON(w, http.StatusOK, hosts)
+}
+
+func (s *Server) listUpstreams(w http.ResponseWriter) {
+	upstreams := []Upstream{}
+	for _, record := range s.resolver.Upstreams() {
+		upstreams = append(upstreams, Upstream{ID: record.ID, Address: record.Address, Port: record.Port, Domains: record.Domains, Source: Source(record.ID)})
+	}
+	writeJSON(w, http.StatusOK, upstreams)
+}
+
+// addHost adds or replaces a host, with the id given or else its first name.
+func (s *Server) addHost(w http.ResponseWriter, req *http.Request) {
+	var host Host
+	if err := json.NewDecoder(req.Body).Decode(&host); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ip := net.ParseIP(host.Address)
+	if ip == nil || ip.To4() == nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid IPv4 address %q", host.Address))
+		return
+	}
+	if len(host.Names) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("missing names"))
+		return
+	}
+	for _, name := range host.Names {
+		if _, ok := dns.IsDomainName(name); !ok || name == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid name %q", name))
+			return
+		}
+	}
+
+	host.ID = manualID(host.ID, host.Names[0])
+	host.Address = ip.String()
+	host.Source = SourceManual
+	if err := s.resolver.AddHost(host.ID, ip, host.Names[0], host.Names[1:]...); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("api: added host %s: %s %s", host.ID, host.Address, strings.Join(host.Names, " "))
+	writeJSON(w, http.StatusCreated, host)
+}
+
+// addUpstream adds or replaces an upstream, with the id given or else its
+// address and port.
+func (s *Server) addUpstream(w http.ResponseWriter, req *http.Request) {
+	var upstream Upstream
+	if err := json.NewDecoder(req.Body).Decode(&upstream); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ip := net.ParseIP(upstream.Address)
+	if ip == nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid address %q", upstream.Address))
+		return
+	}
+	if upstream.Port == 0 {
+		upstream.Port = 53
+	}
+	if upstream.Port < 0 || upstream.Port > 65535 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid port %d", upstream.Port))
+		return
+	}
+	for _, domain := range upstream.Domains {
+		if _, ok := dns.IsDomainName(domain); !ok || domain == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid domain %q", domain))
+			return
+		}
+	}
+
+	upstream.Address = ip.String()
+	upstream.ID = manualID(upstream.ID, net.JoinHostPort(upstream.Address, fmt.Sprint(upstream.Port)))
+	upstream.Source = SourceManual
+	if err := s.resolver.AddUpstream(upstream.ID, ip, upstream.Port, upstream.Domains...); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("api: added upstream %s: %s:%d %s", upstream.ID, upstream.Address, upstream.Port, strings.Join(upstream.Domains, " "))
+	writeJSON(w, http.StatusCreated, upstream)
+}
+
+// remove removes a host or upstream added through the API. The others are
+// owned by their sources, which would add them back.
+func (s *Server) remove(w http.ResponseWriter, id string, remove func(string) error) {
+	if Source(id) != SourceManual {
+		writeError(w, http.StatusForbidden, fmt.Errorf("%s was not added through the API", id))
+		return
+	}
+	if err := remove(id); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Println("api: removed", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// manualID returns id in the namespace of the API, or fallback if id is empty.
+func manualID(id, fallback string) string {
+	if id == "" {
+		id = fallback
+	}
+	if !strings.HasPrefix(id, ManualPrefix) {
+		id = ManualPrefix + id
+	}
+	return id
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
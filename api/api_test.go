@@ -0,0 +1,225 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gliderlabs/resolvable/resolver"
+)
+
+func request(t *testing.T, s *Server, method, path, body string, status int, result interface{}) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Host = "localhost"
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	if w.Code != status {
+		t.Fatalf("%s %s: expected status %d, got %d: %s", method, path, status, w.Code, w.Body)
+	}
+	if result != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), result); err != nil {
+			t.Fatalf("%s %s: invalid JSON: %s", method, path, err)
+		}
+	}
+}
+
+func TestHosts(t *testing.T) {
+	dns, err := resolver.NewResolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dns.AddHost("0123456789ab", net.ParseIP("172.17.0.2"), "web", "web.docker")
+	s := NewServer(dns)
+
+	var host Host
+	request(t, s, "POST", "/hosts", `{"address": "10.0.0.1", "names": ["fake", "fake.docker"]}`, http.StatusCreated, &host)
+	if host.ID != "api:fake" || host.Source != SourceManual {
+		t.Errorf("unexpected host added: %+v", host)
+	}
+
+	var hosts []Host
+	request(t, s, "GET", "/hosts", "", http.StatusOK, &hosts)
+	expected := []Host{
+		{ID: "api:fake", Address: "10.0.0.1", Names: []string{"fake", "fake.docker"}, Source: SourceManual},
+		{ID: "0123456789ab", Address: "172.17.0.2", Names: []string{"web", "web.docker"}, Source: SourceDocker},
+	}
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("expected hosts %+v, got %+v", expected, hosts)
+	}
+
+	request(t, s, "DELETE", "/hosts/0123456789ab", "", http.StatusForbidden, nil)
+	request(t, s, "DELETE", "/hosts/api:fake", "", http.StatusNoContent, nil)
+	request(t, s, "GET", "/hosts", "", http.StatusOK, &hosts)
+	if len(hosts) != 1 || hosts[0].Source != SourceDocker {
+		t.Errorf("expected only the container left, got %+v", hosts)
+	}
+
+	request(t, s, "POST", "/hosts", `{"address": "::1", "names": ["fake"]}`, http.StatusBadRequest, nil)
+	request(t, s, "POST", "/hosts", `{"address": "10.0.0.1"}`, http.StatusBadRequest, nil)
+	request(t, s, "PUT", "/hosts", "", http.StatusMethodNotAllowed, nil)
+	request(t, s, "GET", "/other", "", http.StatusNotFound, nil)
+}
+
+func TestUpstreams(t *testing.T) {
+	dns, err := resolver.NewResolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dns.AddUpstream("resolv.conf:8.8.8.8", net.ParseIP("8.8.8.8"), 53)
+	s := NewServer(dns)
+
+	var upstream Upstream
+	request(t, s, "POST", "/upstreams", `{"id": "consul", "address": "127.0.0.1", "port": 8600, "domains": ["consul"]}`, http.StatusCreated, &upstream)
+	if upstream.ID != "api:consul" {
+		t.Errorf("unexpected upstream added: %+v", upstream)
+	}
+
+	var upstreams []Upstream
+	request(t, s, "GET", "/upstreams", "", http.StatusOK, &upstreams)
+	expected := []Upstream{
+		{ID: "resolv.conf:8.8.8.8", Address: "8.8.8.8", Port: 53, Domains: []string{}, Source: SourceConfig},
+		{ID: "api:consul", Address: "127.0.0.1", Port: 8600, Domains: []string{"consul"}, Source: SourceManual},
+	}
+	if !reflect.DeepEqual(upstreams, expected) {
+		t.Errorf("expected upstreams %+v, got %+v", expected, upstreams)
+	}
+
+	request(t, s, "DELETE", "/upstreams/api:consul", "", http.StatusNoContent, nil)
+	if got := dns.Upstreams(); len(got) != 1 {
+		t.Errorf("expected one upstream left, got %+v", got)
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	for addr, valid := range map[string]bool{
+		"unix:/run/resolvable.sock": true,
+		"127.0.0.1:8053":            true,
+		"[::1]:8053":                true,
+		"localhost:8053":            true,
+		"0.0.0.0:8053":              false,
+		"192.168.1.10:8053":         false,
+		"unix:":                     false,
+		"127.0.0.1":                 false,
+	} {
+		if _, _, err := ParseAddress(addr); (err == nil) != valid {
+			t.Errorf("%s: expected valid %v, got error %v", addr, valid, err)
+		}
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "api.sock")
+
+	dns, err := resolver.NewResolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(dns)
+	if err := s.Listen("unix:" + path); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) { return net.Dial("unix", path) },
+	}}
+	resp, err := client.Get("http://localhost/hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	s.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected socket to be removed on close")
+	}
+}
+
+func TestForbidden(t *testing.T) {
+	dns, err := resolver.NewResolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(dns)
+	body := `{"address": "10.0.0.1", "names": ["fake"]}`
+
+	for _, test := range []struct {
+		host, contentType string
+		status            int
+	}{
+		{"localhost", "application/json", http.StatusCreated},
+		{"127.0.0.1:8053", "application/json; charset=utf-8", http.StatusCreated},
+		{"[::1]:8053", "application/json", http.StatusCreated},
+		// a page sending a simple request, without a preflight
+		{"localhost:8053", "text/plain", http.StatusUnsupportedMediaType},
+		{"localhost", "", http.StatusUnsupportedMediaType},
+		// a page using DNS rebinding
+		{"attacker.example.com:8053", "application/json", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("POST", "/hosts", strings.NewReader(body))
+		req.Host = test.host
+		req.Header.Set("Content-Type", test.contentType)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Errorf("host %s, content type %q: expected status %d, got %d", test.host, test.contentType, test.status, w.Code)
+		}
+	}
+}
+
+func TestListenNotSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "resolv.conf")
+	if err := ioutil.WriteFile(path, []byte("nameserver 8.8.8.8\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dns, err := resolver.NewResolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewServer(dns).Listen("unix:" + path); err == nil {
+		t.Fatal("expected an error listening on a file that is not a socket")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error("expected the file to be left as it is:", err)
+	}
+}
+
+func TestSource(t *testing.T) {
+	for id, expected := range map[string]string{
+		"0123456789ab":                SourceDocker,
+		"bridge:docker0":              SourceDocker,
+		"api:fake":                    SourceManual,
+		"static:/etc/hosts:10.0.0.1":  SourceStatic,
+		"upstream:8.8.8.8":            SourceConfig,
+		"resolv.conf:8.8.8.8":         SourceConfig,
+		"update:fake.docker:10.0.0.1": SourceDynamic,
+	} {
+		if source := Source(id); source != expected {
+			t.Errorf("%s: expected source %s, got %s", id, expected, source)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/gliderlabs/resolvable/resolver"
+)
+
+// hostConfigs calls the registered host resolver configs one at a time, so
+// they are updated in order as the address and info change, and modules don't
+// need their own locking.
+type hostConfigs struct {
+	sync.Mutex
+}
+
+// enableModules unregisters the host modules that are not named, unless no
+// names are given.
+func enableModules(names []string) {
+	if len(names) == 0 {
+		return
+	}
+
+	enabled := make(map[string]bool)
+	for _, name := range names {
+		enabled[name] = true
+		if resolver.HostResolverConfigs.Lookup(name) == nil {
+			log.Printf("module %s is not available", name)
+		}
+	}
+	for name := range resolver.HostResolverConfigs.All() {
+		if !enabled[name] {
+			resolver.HostResolverConfigs.Unregister(name)
+		}
+	}
+}
+
+func (c *hostConfigs) storeAddress(address string) {
+	c.Lock()
+	defer c.Unlock()
+
+	for name, conf := range resolver.HostResolverConfigs.All() {
+		if err := conf.StoreAddress(address); err != nil {
+			log.Printf("[ERROR] error in %s: %s", name, err)
+		}
+	}
+}
+
+func (c *hostConfigs) updateInfo(info resolver.ResolverInfo) {
+	c.Lock()
+	defer c.Unlock()
+
+	for name, conf := range resolver.HostResolverConfigs.All() {
+		if infoConf, ok := conf.(resolver.InfoConfig); ok {
+			if err := infoConf.UpdateInfo(info); err != nil {
+				log.Printf("[ERROR] error in %s: %s", name, err)
+			}
+		}
+	}
+}
+
+func (c *hostConfigs) updateRecords(records []resolver.HostRecord) {
+	c.Lock()
+	defer c.Unlock()
+
+	for name, conf := range resolver.HostResolverConfigs.All() {
+		if recordsConf, ok := conf.(resolver.RecordsConfig); ok {
+			if err := recordsConf.UpdateRecords(records); err != nil {
+				log.Printf("[ERROR] error in %s: %s", name, err)
+			}
+		}
+	}
+}
+
+func (c *hostConfigs) watch(m resolver.Monitor) {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, conf := range resolver.HostResolverConfigs.All() {
+		if watcher, ok := conf.(resolver.MonitoredConfig); ok {
+			watcher.Watch(m)
+		}
+	}
+}
+
+// status returns the problems reported by the configs implementing
+// resolver.StatusConfig, sorted by name.
+func (c *hostConfigs) status() []string {
+	c.Lock()
+	defer c.Unlock()
+
+	var problems []string
+	for name, conf := range resolver.HostResolverConfigs.All() {
+		if statusConf, ok := conf.(resolver.StatusConfig); ok {
+			if err := statusConf.Status(); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s", name, err))
+			}
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+func (c *hostConfigs) clean() {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, conf := range resolver.HostResolverConfigs.All() {
+		conf.Clean()
+	}
+}
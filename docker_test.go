@@ -545,7 +545,8 @@ func NewDebugResolver(client *dockerapi.Client) *DebugResolver {
 }
 
 func (r *DebugResolver) Run() {
-	registerContainers(r.client, r.events, r, "docker", r.hostIP)
+	names, _ := parseNameTemplates(splitList(defaultNameTemplates), []string{"docker"})
+	registerContainers(r.client, r.events, r, names, func() net.IP { return r.hostIP })
 }
 
 func (r *DebugResolver) Cleanup() {
@@ -564,6 +565,10 @@ func (r *DebugResolver) RemoveHost(id string) error {
 	return nil
 }
 
+func (r *DebugResolver) SetPreferred(id string, preferred bool) error {
+	return nil
+}
+
 func (r *DebugResolver) AddUpstream(id string, addr net.IP, port int, domains ...string) error {
 	r.ch <- fmt.Sprintf("add upstream: %v %v %v %v", id, addr, port, domains)
 	return nil
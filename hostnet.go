@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/gliderlabs/resolvable/resolver"
+)
+
+// hostNetwork provides the address of containers running with --net=host,
+// and updates the containers already registered when the address changes.
+type hostNetwork struct {
+	resolver.Resolver
+	sync.Mutex
+	ip    net.IP
+	hosts map[string][]string
+}
+
+func newHostNetwork(dns resolver.Resolver, ip net.IP) *hostNetwork {
+	return &hostNetwork{Resolver: dns, ip: ip, hosts: make(map[string][]string)}
+}
+
+// IP returns the current address for containers using the host network.
+func (h *hostNetwork) IP() net.IP {
+	h.Lock()
+	defer h.Unlock()
+	return h.ip
+}
+
+// SetIP changes the address of the registered containers using the host
+// network.
+func (h *hostNetwork) SetIP(ip net.IP) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.ip = ip
+	for id, names := range h.hosts {
+		h.Resolver.AddHost(id, ip, names[0], names[1:]...)
+	}
+}
+
+func (h *hostNetwork) AddHost(id string, addr net.IP, name string, aliases ...string) error {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.ip != nil && addr.Equal(h.ip) && !strings.HasPrefix(id, "bridge:") {
+		h.hosts[id] = append([]string{name}, aliases...)
+	} else {
+		delete(h.hosts, id)
+	}
+	return h.Resolver.AddHost(id, addr, name, aliases...)
+}
+
+func (h *hostNetwork) RemoveHost(id string) error {
+	h.Lock()
+	delete(h.hosts, id)
+	h.Unlock()
+
+	return h.Resolver.RemoveHost(id)
+}
@@ -0,0 +1,163 @@
+package hostsfile
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/resolvable/resolver"
+	"github.com/gliderlabs/resolvable/settings"
+)
+
+const (
+	blockBegin = "# begin resolvable, removed when it stops"
+	blockEnd   = "# end resolvable"
+)
+
+// blockPattern matches the block of hosts written by resolvable, so the rest
+// of the file is left as it is.
+var blockPattern = regexp.MustCompile("(?s)" + regexp.QuoteMeta(blockBegin) + "\n.*?" + regexp.QuoteMeta(blockEnd) + "(?:\n|\\z)")
+
+// HostsFileConfig exports the hosts served by resolvable, for tools that
+// can't query DNS: to an /etc/hosts-style file, in a block that can share the
+// file with other entries, and to a zone file for the local domain.
+type HostsFileConfig struct {
+	path     string
+	zonePath string
+
+	address string
+	domain  string
+	records []resolver.HostRecord
+	loaded  bool
+}
+
+func init() {
+	path := settings.String("HOSTS_FILE", "", "hosts file to export the hosts served to")
+	zonePath := settings.String("HOSTS_ZONE_FILE", "", "zone file to export the hosts served in the local domain to")
+	if path == "" && zonePath == "" {
+		log.Println("hostsfile: disabled, HOSTS_FILE and HOSTS_ZONE_FILE not set")
+		return
+	}
+	resolver.HostResolverConfigs.Register(&HostsFileConfig{path: path, zonePath: zonePath}, "hostsfile")
+}
+
+func (r *HostsFileConfig) StoreAddress(address string) error {
+	r.address = address
+	return r.writeZone()
+}
+
+// UpdateInfo rewrites the zone file when the local domain changes.
+func (r *HostsFileConfig) UpdateInfo(info resolver.ResolverInfo) error {
+	if info.LocalDomain == r.domain {
+		return nil
+	}
+	r.domain = info.LocalDomain
+	return r.writeZone()
+}
+
+func (r *HostsFileConfig) UpdateRecords(records []resolver.HostRecord) error {
+	r.records = records
+	r.loaded = true
+
+	if err := r.writeHosts(); err != nil {
+		return err
+	}
+	return r.writeZone()
+}
+
+func (r *HostsFileConfig) Clean() {
+	if r.path != "" {
+		if err := updateHostsFile(r.path, nil); err != nil {
+			log.Println("hostsfile: error cleaning", r.path+":", err)
+		}
+	}
+	if r.zonePath != "" {
+		os.Remove(r.zonePath)
+	}
+}
+
+func (r *HostsFileConfig) writeHosts() error {
+	if r.path == "" {
+		return nil
+	}
+	log.Println("hostsfile: updating", r.path)
+	return updateHostsFile(r.path, hostsBlock(r.records))
+}
+
+func (r *HostsFileConfig) writeZone() error {
+	if r.zonePath == "" || !r.loaded || r.domain == "" {
+		return nil
+	}
+	log.Println("hostsfile: updating", r.zonePath)
+	zone := zoneFile(r.records, r.domain, r.address, uint32(time.Now().Unix()))
+	return resolver.WriteFileAtomic(r.zonePath, zone, 0644)
+}
+
+// updateHostsFile replaces the block written by resolvable in the file at
+// path, or removes it if block is nil.
+func updateHostsFile(path string, block []byte) error {
+	orig, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(blockPattern.ReplaceAllLiteral(orig, nil))
+	if block != nil {
+		if buf.Len() > 0 && buf.Bytes()[buf.Len()-1] != '\n' {
+			buf.WriteString("\n")
+		}
+		buf.Write(block)
+	}
+	return resolver.WriteFileAtomic(path, buf.Bytes(), 0644)
+}
+
+func hostsBlock(records []resolver.HostRecord) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(blockBegin + "\n")
+	for _, record := range records {
+		fmt.Fprintf(&buf, "%s\t%s\n", record.Address, strings.Join(record.Names, " "))
+	}
+	buf.WriteString(blockEnd + "\n")
+	return buf.Bytes()
+}
+
+// zoneFile returns an RFC 1035 master file with the A records of the names
+// in domain, served by resolvable at address.
+func zoneFile(records []resolver.HostRecord, domain, address string, serial uint32) []byte {
+	domain = strings.ToLower(strings.Trim(domain, "."))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "; generated by resolvable, removed when it stops\n")
+	fmt.Fprintf(&buf, "$ORIGIN %s.\n$TTL 0\n", domain)
+	fmt.Fprintf(&buf, "@\tIN\tSOA\tns hostmaster %d 3600 600 86400 0\n", serial)
+	fmt.Fprintf(&buf, "@\tIN\tNS\tns\n")
+	if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+		fmt.Fprintf(&buf, "ns\tIN\tA\t%s\n", address)
+	}
+
+	for _, record := range records {
+		if ip := net.ParseIP(record.Address); ip == nil || ip.To4() == nil {
+			continue
+		}
+		for _, name := range record.Names {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			switch {
+			case name == domain:
+				name = "@"
+			case strings.HasSuffix(name, "."+domain):
+				name = strings.TrimSuffix(name, "."+domain)
+			default:
+				continue
+			}
+			fmt.Fprintf(&buf, "%s\tIN\tA\t%s\n", name, record.Address)
+		}
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,115 @@
+package hostsfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gliderlabs/resolvable/resolver"
+)
+
+var records = []resolver.HostRecord{
+	{Address: "172.17.0.2", Names: []string{"0123456789ab", "web.docker"}},
+	{Address: "192.168.1.10", Names: []string{"nas", "nas.docker"}},
+}
+
+func tempFile(t *testing.T, name, contents string) (string, func()) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("could not create temp dir:", err)
+	}
+	path := filepath.Join(dir, name)
+	if contents != "" {
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal("could not create file:", err)
+		}
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func readFile(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal("could not read file:", err)
+	}
+	return string(data)
+}
+
+func TestHostsFile(t *testing.T) {
+	orig := "127.0.0.1\tlocalhost\n::1\tlocalhost"
+	path, cleanup := tempFile(t, "hosts", orig)
+	defer cleanup()
+
+	conf := &HostsFileConfig{path: path}
+	if err := conf.StoreAddress("172.17.42.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.UpdateRecords(records); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := orig + "\n" + blockBegin + "\n" +
+		"172.17.0.2\t0123456789ab web.docker\n" +
+		"192.168.1.10\tnas nas.docker\n" +
+		blockEnd + "\n"
+	if got := readFile(t, path); got != expected {
+		t.Errorf("expected:\n%s\nbut got:\n%s", expected, got)
+	}
+
+	// the block is replaced, leaving the other entries
+	if err := conf.UpdateRecords(records[1:]); err != nil {
+		t.Fatal(err)
+	}
+	expected = orig + "\n" + blockBegin + "\n192.168.1.10\tnas nas.docker\n" + blockEnd + "\n"
+	if got := readFile(t, path); got != expected {
+		t.Errorf("expected:\n%s\nbut got:\n%s", expected, got)
+	}
+
+	conf.Clean()
+	if got := readFile(t, path); got != orig+"\n" {
+		t.Errorf("expected original contents after clean, got:\n%s", got)
+	}
+}
+
+func TestZoneFile(t *testing.T) {
+	path, cleanup := tempFile(t, "docker.zone", "")
+	defer cleanup()
+
+	conf := &HostsFileConfig{zonePath: path}
+	if err := conf.StoreAddress("172.17.42.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.UpdateInfo(resolver.ResolverInfo{LocalDomain: "docker"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("expected no zone file before the records are known")
+	}
+	if err := conf.UpdateRecords(records); err != nil {
+		t.Fatal(err)
+	}
+
+	zone := readFile(t, path)
+	for _, expected := range []string{
+		"$ORIGIN docker.\n",
+		"@\tIN\tNS\tns\n",
+		"ns\tIN\tA\t172.17.42.1\n",
+		"web\tIN\tA\t172.17.0.2\n",
+		"nas\tIN\tA\t192.168.1.10\n",
+	} {
+		if !strings.Contains(zone, expected) {
+			t.Errorf("expected %q in zone file:\n%s", expected, zone)
+		}
+	}
+	// names outside the local domain are left out
+	if strings.Contains(zone, "0123456789ab") {
+		t.Errorf("expected only names in the local domain in zone file:\n%s", zone)
+	}
+
+	conf.Clean()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected zone file to be removed")
+	}
+}
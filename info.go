@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gliderlabs/resolvable/resolver"
+)
+
+// infoUpdater keeps the host resolver configs implementing
+// resolver.InfoConfig up to date with what is being served.
+type infoUpdater struct {
+	sync.Mutex
+	configs *hostConfigs
+	info    resolver.ResolverInfo
+	domains func() []string
+	port    func() int
+	bridges []string
+}
+
+func (u *infoUpdater) addBridge(name string) {
+	u.Lock()
+	defer u.Unlock()
+
+	for _, bridge := range u.bridges {
+		if bridge == name {
+			return
+		}
+	}
+	u.bridges = append(u.bridges, name)
+	sort.Strings(u.bridges)
+}
+
+// address returns the address resolvable is advertised with.
+func (u *infoUpdater) address() string {
+	u.Lock()
+	defer u.Unlock()
+	return u.info.Address
+}
+
+// setAddress changes the address resolvable is advertised with.
+func (u *infoUpdater) setAddress(address string) {
+	u.Lock()
+	u.info.Address = address
+	u.Unlock()
+
+	u.update(true)
+}
+
+// update refreshes the domains, port and bridges, and passes the info on to
+// the host resolver configs if it changed, or if force is set.
+func (u *infoUpdater) update(force bool) {
+	u.Lock()
+	defer u.Unlock()
+
+	info := u.info
+	info.Domains = u.domains()
+	info.Port = u.port()
+	info.Bridges = append([]string{}, u.bridges...)
+
+	if !force && reflect.DeepEqual(info, u.info) {
+		return
+	}
+	u.info = info
+
+	u.configs.updateInfo(info)
+}
+
+// listeningAddresses returns the addresses the resolver is bound to, from the
+// sockets passed in by systemd if any.
+func listeningAddresses(addrs []net.IP, conns []net.PacketConn) []string {
+	var listening []string
+	for _, conn := range conns {
+		if udp, ok := conn.LocalAddr().(*net.UDPAddr); ok && !udp.IP.IsUnspecified() {
+			listening = append(listening, udp.IP.String())
+		}
+	}
+	if len(conns) > 0 {
+		return listening
+	}
+
+	for _, ip := range addrs {
+		listening = append(listening, ip.String())
+	}
+	return listening
+}
+
+// notifyingResolver updates the host resolver configs when upstream servers
+// or bridges are added, and once the resolver is listening.
+type notifyingResolver struct {
+	resolver.Resolver
+	info *infoUpdater
+}
+
+func (r *notifyingResolver) AddHost(id string, addr net.IP, name string, aliases ...string) error {
+	err := r.Resolver.AddHost(id, addr, name, aliases...)
+	if strings.HasPrefix(id, "bridge:") {
+		r.info.addBridge(name)
+		r.info.update(false)
+	}
+	return err
+}
+
+func (r *notifyingResolver) AddUpstream(id string, addr net.IP, port int, domains ...string) error {
+	err := r.Resolver.AddUpstream(id, addr, port, domains...)
+	r.info.update(false)
+	return err
+}
+
+func (r *notifyingResolver) RemoveUpstream(id string) error {
+	err := r.Resolver.RemoveUpstream(id)
+	r.info.update(false)
+	return err
+}
+
+func (r *notifyingResolver) Listen() error {
+	err := r.Resolver.Listen()
+	r.info.update(false)
+	return err
+}
+
+// apiResolver updates the resolver through notifyingResolver, so the host
+// resolver configs learn about the upstreams added through the API.
+type apiResolver struct {
+	*notifyingResolver
+	records interface {
+		Records() []resolver.HostRecord
+		Upstreams() []resolver.UpstreamRecord
+	}
+}
+
+func (r *apiResolver) Records() []resolver.HostRecord {
+	return r.records.Records()
+}
+
+func (r *apiResolver) Upstreams() []resolver.UpstreamRecord {
+	return r.records.Upstreams()
+}
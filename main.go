@@ -7,43 +7,22 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
 
-	"github.com/miekg/dns"
-
+	"github.com/gliderlabs/resolvable/api"
 	"github.com/gliderlabs/resolvable/resolver"
+	"github.com/gliderlabs/resolvable/settings"
 
+	"github.com/coreos/go-systemd/daemon"
 	dockerapi "github.com/fsouza/go-dockerclient"
+	"github.com/joeshaw/multierror"
 )
 
 var Version string
 
-func getopt(name, def string) string {
-	if env := os.Getenv(name); env != "" {
-		return env
-	}
-	return def
-}
-
-func ipAddress() (string, error) {
-	addrs, err := net.InterfaceAddrs()
-	if err != nil {
-		return "", err
-	}
-
-	for _, address := range addrs {
-		if ipnet, ok := address.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && !ipnet.IP.IsMulticast() {
-			if ipv4 := ipnet.IP.To4(); ipv4 != nil {
-				return ipv4.String(), nil
-			}
-		}
-	}
-
-	return "", errors.New("no addresses found")
-}
-
 func parseContainerEnv(containerEnv []string, prefix string) map[string]string {
 	parsed := make(map[string]string)
 
@@ -62,7 +41,7 @@ func parseContainerEnv(containerEnv []string, prefix string) map[string]string {
 	return parsed
 }
 
-func registerContainers(docker *dockerapi.Client, events chan *dockerapi.APIEvents, dns resolver.Resolver, containerDomain string, hostIP net.IP) error {
+func registerContainers(docker *dockerapi.Client, events chan *dockerapi.APIEvents, dns resolver.Resolver, names *containerNames, hostIP func() net.IP) error {
 	// TODO add an options struct instead of passing all as parameters
 	// though passing the events channel from an options struct was triggering
 	// data race warnings within AddEventListener, so needs more investigation
@@ -74,10 +53,6 @@ func registerContainers(docker *dockerapi.Client, events chan *dockerapi.APIEven
 		return err
 	}
 
-	if !strings.HasPrefix(containerDomain, ".") {
-		containerDomain = "." + containerDomain
-	}
-
 	getAddress := func(container *dockerapi.Container) (net.IP, error) {
 		for {
 			if container.NetworkSettings.IPAddress != "" {
@@ -85,10 +60,10 @@ func registerContainers(docker *dockerapi.Client, events chan *dockerapi.APIEven
 			}
 
 			if container.HostConfig.NetworkMode == "host" {
-				if hostIP == nil {
+				if ip := hostIP(); ip == nil {
 					return nil, errors.New("IP not available with network mode \"host\"")
 				} else {
-					return hostIP, nil
+					return ip, nil
 				}
 			}
 
@@ -116,7 +91,14 @@ func registerContainers(docker *dockerapi.Client, events chan *dockerapi.APIEven
 			return err
 		}
 
-		err = dns.AddHost(containerId, addr, container.Config.Hostname, container.Name[1:]+containerDomain)
+		hostNames := names.names(container)
+		if len(hostNames) == 0 {
+			return errors.New("no valid names from NAME_TEMPLATES")
+		}
+		if err = dns.SetPreferred(containerId, preferredOwner(container)); err != nil {
+			return err
+		}
+		err = dns.AddHost(containerId, addr, hostNames[0], hostNames[1:]...)
 		if err != nil {
 			return err
 		}
@@ -186,40 +168,79 @@ func registerContainers(docker *dockerapi.Client, events chan *dockerapi.APIEven
 	return errors.New("docker event loop closed")
 }
 
-func run() error {
+// preferredLabel marks a container as the preferred owner of its names, when
+// other containers register them too.
+const preferredLabel = "resolvable.preferred"
+
+func preferredOwner(container *dockerapi.Container) bool {
+	if container.Config == nil {
+		return false
+	}
+	value, ok := container.Config.Labels[preferredLabel]
+	if !ok {
+		return false
+	}
+	preferred, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("invalid %s label %q for container %s, expected true or false", preferredLabel, value, shortID(container.ID))
+	}
+	return preferred
+}
+
+func run(o *options) error {
 	// set up the signal handler first to ensure cleanup is handled if a signal is
 	// caught while initializing
 	exitReason := make(chan error)
+	// a reload requested while initializing is done once running
+	reload := make(chan struct{}, 1)
 	go func() {
 		c := make(chan os.Signal, 1)
-		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-		sig := <-c
-		log.Println("exit requested by signal:", sig)
-		exitReason <- nil
+		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+		for sig := range c {
+			if sig == syscall.SIGHUP {
+				select {
+				case reload <- struct{}{}:
+				default:
+				}
+				continue
+			}
+			log.Println("exit requested by signal:", sig)
+			exitReason <- nil
+			return
+		}
 	}()
 
-	docker, err := dockerapi.NewClient(getopt("DOCKER_HOST", "unix:///tmp/docker.sock"))
+	docker, err := dockerapi.NewClient(o.dockerHost)
 	if err != nil {
 		return err
 	}
 
-	address, err := ipAddress()
+	listenAddrs, err := listenAddresses(o)
 	if err != nil {
 		return err
 	}
-	log.Println("got local address:", address)
 
-	for name, conf := range resolver.HostResolverConfigs.All() {
-		err := conf.StoreAddress(address)
-		if err != nil {
-			log.Printf("[ERROR] error in %s: %s", name, err)
-		}
-		defer conf.Clean()
+	address, err := advertiseAddress(o, listenAddrs)
+	if err != nil {
+		return err
 	}
+	log.Println("got local address:", address)
+
+	enableModules(o.modules)
+	configs := &hostConfigs{}
+	configs.storeAddress(address)
+	defer configs.clean()
 
 	var hostIP net.IP
-	if envHostIP := os.Getenv("HOST_IP"); envHostIP != "" {
-		hostIP = net.ParseIP(envHostIP)
+	followHostIP := false
+	switch o.hostIP {
+	case "":
+	case "auto":
+		hostIP = net.ParseIP(address)
+		followHostIP = true
+		log.Println("using local address for --net=host:", hostIP)
+	default:
+		hostIP = net.ParseIP(o.hostIP)
 		log.Println("using address for --net=host:", hostIP)
 	}
 
@@ -229,32 +250,133 @@ func run() error {
 	}
 	defer dnsResolver.Close()
 
-	localDomain := "docker"
-	dnsResolver.AddUpstream(localDomain, nil, 0, localDomain)
+	dnsResolver.Port = o.port
+	dnsResolver.Addresses = listenAddrs
+	if len(listenAddrs) > 0 {
+		log.Println("listening on:", listenAddrs)
+	}
 
-	resolvConfig, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	dnsResolver.PacketConns, dnsResolver.Listeners, err = activationSockets()
 	if err != nil {
 		return err
 	}
-	resolvConfigPort, err := strconv.Atoi(resolvConfig.Port)
+	if len(dnsResolver.PacketConns) > 0 || len(dnsResolver.Listeners) > 0 {
+		log.Printf("using %d UDP and %d TCP sockets from systemd", len(dnsResolver.PacketConns), len(dnsResolver.Listeners))
+	}
+
+	records := &recordsUpdater{configs: configs, records: dnsResolver.Records}
+	defer records.stop()
+	dnsResolver.OnHostsChanged(records.changed)
+	records.changed()
+
+	names, err := parseNameTemplates(o.nameTemplates, o.localDomains)
 	if err != nil {
-		return err
+		return fmt.Errorf("NAME_TEMPLATES: %s", err)
 	}
-	for _, server := range resolvConfig.Servers {
-		if server != address {
-			dnsResolver.AddUpstream("resolv.conf:"+server, net.ParseIP(server), resolvConfigPort)
+	if o.nameSanitize {
+		names.sanitizer, err = newSanitizer(o.nameReplace)
+		if err != nil {
+			return fmt.Errorf("NAME_REPLACE: %s", err)
 		}
 	}
+	names.raw = o.nameRaw
+	for _, domain := range o.localDomains {
+		dnsResolver.AddUpstream(domain, nil, 0, domain)
+	}
 
 	go func() {
 		dnsResolver.Wait()
 		exitReason <- errors.New("dns resolver exited")
 	}()
+	info := &infoUpdater{
+		configs: configs,
+		info: resolver.ResolverInfo{
+			Address:     address,
+			Addresses:   listeningAddresses(dnsResolver.Addresses, dnsResolver.PacketConns),
+			LocalDomain: o.localDomains[0],
+		},
+		domains: func() []string {
+			// the other local domains are routed to resolvable like forwarded ones
+			return append(append([]string{}, o.localDomains[1:]...), dnsResolver.Domains()...)
+		},
+		port: func() int { return dnsResolver.Port },
+	}
+	info.update(true)
+
+	live := &liveConfig{
+		resolver: dnsResolver,
+		ignore: func(server string) bool {
+			// don't forward queries back to resolvable
+			return server == info.address()
+		},
+		domain:      o.localDomains[0],
+		updateZones: o.localDomains,
+	}
+	defer live.close()
+	if err := live.apply(o); err != nil {
+		return err
+	}
+
+	hosts := newHostNetwork(&notifyingResolver{dnsResolver, info}, hostIP)
+
+	if o.apiAddr != "" {
+		server := api.NewServer(&apiResolver{&notifyingResolver{dnsResolver, info}, dnsResolver})
+		if err := server.Listen(o.apiAddr); err != nil {
+			return fmt.Errorf("API_ADDR: %s", err)
+		}
+		defer server.Close()
+	}
+
+	// an address set explicitly doesn't change
+	if o.advertiseAddr == "" {
+		follower, err := followAddress(o, listenAddrs, address, func(address string) {
+			log.Println("local address changed:", address)
+			configs.storeAddress(address)
+			info.setAddress(address)
+			if followHostIP {
+				hosts.SetIP(net.ParseIP(address))
+			}
+		})
+		if err != nil {
+			log.Println("not following address changes:", err)
+		} else {
+			defer follower.Close()
+		}
+	}
+
+	// the default allowed networks are those of the interfaces, like the Docker
+	// bridges, which come and go with their networks
+	if access, err := followAddressChanges(live.refreshAccess); err != nil {
+		log.Println("not refreshing the allowed networks:", err)
+	} else {
+		defer access.Close()
+	}
+
+	mon := &monitor{resolver: dnsResolver, docker: docker, configs: configs}
+	configs.watch(mon)
+	stopStatus := mon.logStatus(statusInterval)
+	defer close(stopStatus)
+
+	// listening and configured, the containers are registered as they are
+	// listed and started
+	daemon.SdNotify("READY=1")
+
 	go func() {
-		exitReason <- registerContainers(docker, nil, dnsResolver, localDomain, hostIP)
+		mon.setFollowingEvents(true)
+		err := registerContainers(docker, nil, hosts, names, hosts.IP)
+		mon.setFollowingEvents(false)
+		exitReason <- err
 	}()
 
-	return <-exitReason
+	for {
+		select {
+		case err := <-exitReason:
+			return err
+		case <-reload:
+			log.Println("reloading config")
+			reloadConfig(live)
+		}
+	}
 }
 
 func main() {
@@ -262,10 +384,43 @@ func main() {
 		fmt.Println(Version)
 		os.Exit(0)
 	}
+
+	o := loadOptions()
+	err := settings.Validate()
+
+	switch args := settings.Args(); {
+	case len(args) == 1 && args[0] == "check-config":
+		os.Exit(checkConfig(o, err))
+	case len(args) > 0:
+		log.Fatalf("resolvable: unknown command %q, expected check-config", strings.Join(args, " "))
+	}
+
+	if err != nil {
+		log.Fatal("resolvable: ", err)
+	}
 	log.Printf("Starting resolvable %s ...", Version)
 
-	err := run()
+	err = run(o)
 	if err != nil {
 		log.Fatal("resolvable: ", err)
 	}
 }
+
+// checkConfig prints the effective settings and the enabled host modules,
+// followed by any problems with them, and returns the exit status.
+func checkConfig(o *options, err error) int {
+	settings.Print(os.Stdout)
+
+	enableModules(o.modules)
+	modules := resolver.HostResolverConfigs.Names()
+	sort.Strings(modules)
+	fmt.Printf("# enabled host modules: %s\n", strings.Join(modules, ", "))
+
+	if multi, ok := err.(*multierror.MultiError); ok {
+		for _, err := range multi.Errors {
+			fmt.Fprintln(os.Stderr, "error:", err)
+		}
+		return 1
+	}
+	return 0
+}
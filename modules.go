@@ -1,6 +1,10 @@
 package main
 
 import (
+	_ "github.com/gliderlabs/resolvable/hostsfile"
+	_ "github.com/gliderlabs/resolvable/networkmanager"
+	_ "github.com/gliderlabs/resolvable/openresolv"
+	_ "github.com/gliderlabs/resolvable/resolved"
 	_ "github.com/gliderlabs/resolvable/resolver"
 	_ "github.com/gliderlabs/resolvable/systemd"
 )
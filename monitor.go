@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	dockerapi "github.com/fsouza/go-dockerclient"
+)
+
+// statusInterval is how often the status of the host configs is checked.
+const statusInterval = 30 * time.Second
+
+type checkedResolver interface {
+	Check() error
+	Stats() (hosts, upstreams int)
+	Conflicts() []string
+}
+
+// monitor implements resolver.Monitor for the running resolver and Docker
+// event loop.
+type monitor struct {
+	resolver checkedResolver
+	docker   *dockerapi.Client
+	configs  *hostConfigs
+	events   int32
+}
+
+func (m *monitor) setFollowingEvents(running bool) {
+	var value int32
+	if running {
+		value = 1
+	}
+	atomic.StoreInt32(&m.events, value)
+}
+
+func (m *monitor) Check() error {
+	if atomic.LoadInt32(&m.events) == 0 {
+		return errors.New("not following docker events")
+	}
+	if err := m.docker.Ping(); err != nil {
+		return fmt.Errorf("docker: %s", err)
+	}
+	return m.resolver.Check()
+}
+
+// Status summarizes what is being served, followed by any name conflicts and
+// problems reported by the host configs.
+func (m *monitor) Status() string {
+	hosts, upstreams := m.resolver.Stats()
+	status := fmt.Sprintf("serving %d hosts, forwarding to %d upstreams", hosts, upstreams)
+	if conflicts := m.resolver.Conflicts(); len(conflicts) > 0 {
+		status += "; name conflicts: " + strings.Join(conflicts, ", ")
+	}
+	if problems := m.configs.status(); len(problems) > 0 {
+		status += "; " + strings.Join(problems, "; ")
+	}
+	return status
+}
+
+// logStatus checks the host configs every interval, and logs when they start
+// or stop reporting problems, until the returned channel is closed.
+func (m *monitor) logStatus(interval time.Duration) chan struct{} {
+	stop := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var last string
+		for {
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+
+			problems := strings.Join(m.configs.status(), "; ")
+			if problems == last {
+				continue
+			}
+			if problems == "" {
+				log.Println("host configs ok")
+			} else {
+				log.Println("[ERROR] host configs:", problems)
+			}
+			last = problems
+		}
+	}()
+
+	return stop
+}
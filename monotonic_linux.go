@@ -0,0 +1,20 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// clockMonotonic is CLOCK_MONOTONIC, which the syscall package lacks.
+const clockMonotonic = 1
+
+// monotonicUsec returns the time of CLOCK_MONOTONIC in microseconds, as
+// systemd expects along with RELOADING=1.
+func monotonicUsec() (int64, bool) {
+	var ts syscall.Timespec
+	_, _, errno := syscall.Syscall(syscall.SYS_CLOCK_GETTIME, clockMonotonic, uintptr(unsafe.Pointer(&ts)), 0)
+	if errno != 0 {
+		return 0, false
+	}
+	return ts.Nano() / 1000, true
+}
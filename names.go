@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"unicode"
+
+	dockerapi "github.com/fsouza/go-dockerclient"
+)
+
+// defaultNameTemplates register the hostname, and the container name in each
+// local domain.
+const defaultNameTemplates = "{{.Hostname}},{{.Name}}.{{.Domain}}"
+
+// defaultNameReplace turns compose v1 names like myapp_web_1 into myapp-web-1.
+const defaultNameReplace = "_=-,.=-"
+
+// nameFields are the container fields available to the name templates.
+type nameFields struct {
+	Name     string
+	Hostname string
+	// the image without registry, tag or digest, e.g. nginx
+	Image string
+	// the compose service and project
+	Service string
+	Project string
+	Labels  map[string]string
+	// the local domain, templates using it are expanded once per domain
+	Domain string
+}
+
+// exampleFields are used to check the templates produce valid names.
+var exampleFields = nameFields{
+	Name:     "web",
+	Hostname: "0123456789ab",
+	Image:    "nginx",
+	Service:  "web",
+	Project:  "app",
+	Labels:   map[string]string{},
+}
+
+// containerNames expands the name templates for containers.
+type containerNames struct {
+	templates []*template.Template
+	domains   []string
+	// sanitizes the container fields into valid labels, if set
+	sanitizer *nameSanitizer
+	// also registers the names from the fields as they are
+	raw bool
+}
+
+// parseNameTemplates parses the templates, and checks they produce valid
+// names for an example container in each domain.
+func parseNameTemplates(values []string, domains []string) (*containerNames, error) {
+	if len(domains) == 0 {
+		return nil, errors.New("no local domain")
+	}
+	names := &containerNames{domains: domains}
+	for _, value := range values {
+		tmpl, err := template.New(value).Option("missingkey=zero").Parse(value)
+		if err != nil {
+			return nil, err
+		}
+		names.templates = append(names.templates, tmpl)
+	}
+
+	fields := exampleFields
+	for _, domain := range domains {
+		fields.Domain = domain
+		for _, tmpl := range names.templates {
+			name, err := expandName(tmpl, fields)
+			if err != nil {
+				return nil, err
+			}
+			if name == "" {
+				continue
+			}
+			if err := checkName(name); err != nil {
+				return nil, fmt.Errorf("%s: %s", tmpl.Name(), err)
+			}
+		}
+	}
+	return names, nil
+}
+
+// names returns the valid names for a container, logging those that are not.
+func (n *containerNames) names(container *dockerapi.Container) []string {
+	raw := containerFields(container)
+
+	// sanitized names first, the first name is used for reverse lookups
+	var fieldSets []nameFields
+	if n.sanitizer != nil {
+		fieldSets = append(fieldSets, n.sanitizer.fields(raw))
+	}
+	if n.raw || n.sanitizer == nil {
+		fieldSets = append(fieldSets, raw)
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, fields := range fieldSets {
+		names = n.expand(container, fields, names, seen)
+	}
+	return names
+}
+
+// expand appends the names not seen yet from the templates in each domain.
+func (n *containerNames) expand(container *dockerapi.Container, fields nameFields, names []string, seen map[string]bool) []string {
+	for _, domain := range n.domains {
+		fields.Domain = domain
+		for _, tmpl := range n.templates {
+			name, err := expandName(tmpl, fields)
+			if err == nil && name != "" {
+				err = checkName(name)
+			}
+			if err != nil {
+				log.Printf("not registering name %q from %s for container %s: %s", name, tmpl.Name(), shortID(container.ID), err)
+				continue
+			}
+			if name != "" && !seen[strings.ToLower(name)] {
+				seen[strings.ToLower(name)] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// nameSanitizer turns container fields into valid RFC 1123 labels.
+type nameSanitizer struct {
+	replace map[rune]string
+}
+
+// newSanitizer parses replacements of characters as from=to pairs, e.g. _=-.
+// Other characters that are not valid in a label are replaced by hyphens.
+func newSanitizer(pairs []string) (*nameSanitizer, error) {
+	s := &nameSanitizer{replace: make(map[rune]string)}
+	for _, pair := range pairs {
+		fromTo := strings.SplitN(pair, "=", 2)
+		from := []rune(fromTo[0])
+		if len(fromTo) != 2 || len(from) != 1 {
+			return nil, fmt.Errorf("invalid replacement %q, expected a character=replacement pair", pair)
+		}
+		for _, c := range fromTo[1] {
+			if !labelChar(c) {
+				return nil, fmt.Errorf("invalid replacement %q, %q is not valid in a label", pair, c)
+			}
+		}
+		s.replace[from[0]] = fromTo[1]
+	}
+	return s, nil
+}
+
+func (s *nameSanitizer) fields(raw nameFields) nameFields {
+	fields := nameFields{
+		Name:     s.label(raw.Name),
+		Hostname: s.hostname(raw.Hostname),
+		Image:    s.label(raw.Image),
+		Service:  s.label(raw.Service),
+		Project:  s.label(raw.Project),
+		Labels:   make(map[string]string),
+	}
+	for key, value := range raw.Labels {
+		fields.Labels[key] = s.label(value)
+	}
+	return fields
+}
+
+// hostname sanitizes each label of a hostname, which may be fully qualified
+// like db.example.com, so its dots are kept.
+func (s *nameSanitizer) hostname(value string) string {
+	var labels []string
+	for _, label := range strings.Split(value, ".") {
+		if label = s.label(label); label != "" {
+			labels = append(labels, label)
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// label lower-cases value and replaces the characters not valid in a label,
+// trimming hyphens from the ends and truncating it to 63 characters.
+func (s *nameSanitizer) label(value string) string {
+	var buf bytes.Buffer
+	for _, c := range value {
+		if replacement, ok := s.replace[c]; ok {
+			buf.WriteString(replacement)
+		} else if c = unicode.ToLower(c); labelChar(c) {
+			buf.WriteRune(c)
+		} else {
+			buf.WriteRune('-')
+		}
+	}
+	label := strings.Trim(buf.String(), "-")
+	if len(label) > 63 {
+		label = strings.TrimRight(label[:63], "-")
+	}
+	return label
+}
+
+// labelChar returns whether c is valid in an RFC 1123 label, in lower case.
+func labelChar(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-'
+}
+
+func expandName(tmpl *template.Template, fields nameFields) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, fields); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSpace(buf.String()), "."), nil
+}
+
+func containerFields(container *dockerapi.Container) nameFields {
+	fields := nameFields{
+		Name:   strings.TrimPrefix(container.Name, "/"),
+		Labels: map[string]string{},
+	}
+	if config := container.Config; config != nil {
+		fields.Hostname = config.Hostname
+		fields.Image = imageName(config.Image)
+		if config.Labels != nil {
+			fields.Labels = config.Labels
+		}
+	}
+	fields.Service = fields.Labels["com.docker.compose.service"]
+	fields.Project = fields.Labels["com.docker.compose.project"]
+	return fields
+}
+
+// imageName returns the name of an image without registry, tag or digest.
+func imageName(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, "/"); i >= 0 {
+		image = image[i+1:]
+	}
+	if i := strings.Index(image, ":"); i >= 0 {
+		image = image[:i]
+	}
+	return image
+}
+
+// checkName checks a name is made of valid DNS labels: up to 63 letters,
+// digits, hyphens or underscores, not starting or ending with a hyphen.
+func checkName(name string) error {
+	if len(name) > 253 {
+		return errors.New("name longer than 253 characters")
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("invalid label %q, must be 1 to 63 characters", label)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("invalid label %q, must not start or end with a hyphen", label)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return fmt.Errorf("invalid character %q in label %q", c, label)
+			}
+		}
+	}
+	return nil
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
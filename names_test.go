@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+
+	dockerapi "github.com/fsouza/go-dockerclient"
+)
+
+func TestNameTemplates(t *testing.T) {
+	names, err := parseNameTemplates([]string{
+		"{{.Hostname}}",
+		"{{.Name}}.{{.Domain}}",
+		"{{.Service}}.{{.Project}}.{{.Domain}}",
+		"{{.Image}}",
+		"{{.Labels.team}}",
+	}, []string{"docker", "local.test"})
+	ok(t, err)
+
+	container := &dockerapi.Container{
+		ID:   "0123456789abcdef",
+		Name: "/app_web_1",
+		Config: &dockerapi.Config{
+			Hostname: "0123456789ab",
+			Image:    "registry.example.com:5000/team/nginx:1.9@sha256:abc",
+			Labels: map[string]string{
+				"com.docker.compose.service": "web",
+				"com.docker.compose.project": "app",
+			},
+		},
+	}
+	equals(t, []string{
+		"0123456789ab",
+		"app_web_1.docker",
+		"web.app.docker",
+		"nginx",
+		"app_web_1.local.test",
+		"web.app.local.test",
+	}, names.names(container))
+}
+
+func TestNameTemplatesSkipInvalid(t *testing.T) {
+	names, err := parseNameTemplates([]string{"{{.Name}}.{{.Domain}}", "{{.Labels.alias}}"}, []string{"docker"})
+	ok(t, err)
+
+	container := &dockerapi.Container{
+		Name:   "/web",
+		Config: &dockerapi.Config{Labels: map[string]string{"alias": "not valid"}},
+	}
+	equals(t, []string{"web.docker"}, names.names(container))
+}
+
+func TestNameTemplatesInvalid(t *testing.T) {
+	for _, templates := range [][]string{
+		{"{{.Name"},
+		{"{{.Unknown}}"},
+		{"{{.Name}}..{{.Domain}}"},
+		{"-{{.Name}}"},
+		{"{{.Image}}:{{.Name}}"},
+	} {
+		if _, err := parseNameTemplates(templates, []string{"docker"}); err == nil {
+			t.Errorf("expected an error for %v", templates)
+		}
+	}
+}
+
+func TestSanitizeNames(t *testing.T) {
+	names, err := parseNameTemplates([]string{"{{.Hostname}}", "{{.Name}}.{{.Domain}}"}, []string{"docker"})
+	ok(t, err)
+	names.sanitizer, err = newSanitizer([]string{"_=-", ".="})
+	ok(t, err)
+
+	container := &dockerapi.Container{
+		Name:   "/MyApp_Web.1",
+		Config: &dockerapi.Config{Hostname: "_Web+Host_"},
+	}
+	equals(t, []string{"web-host", "myapp-web1.docker"}, names.names(container))
+
+	// the raw hostname is not a valid name, so it is skipped
+	names.raw = true
+	equals(t, []string{"web-host", "myapp-web1.docker", "MyApp_Web.1.docker"}, names.names(container))
+}
+
+func TestSanitizeHostname(t *testing.T) {
+	names, err := parseNameTemplates([]string{"{{.Hostname}}", "{{.Name}}.{{.Domain}}"}, []string{"docker"})
+	ok(t, err)
+	names.sanitizer, err = newSanitizer([]string{"_=-", ".=-"})
+	ok(t, err)
+	names.raw = true
+
+	// a fully qualified hostname keeps its dots, and stays the first name
+	container := &dockerapi.Container{
+		Name:   "/db.1",
+		Config: &dockerapi.Config{Hostname: "db.example.com"},
+	}
+	equals(t, []string{"db.example.com", "db-1.docker", "db.1.docker"}, names.names(container))
+
+	container.Config.Hostname = "My_DB..Example.com."
+	equals(t, "my-db.example.com", names.names(container)[0])
+}
+
+func TestSanitizerInvalid(t *testing.T) {
+	for _, pairs := range [][]string{{"_"}, {"ab=-"}, {"_=_"}, {"_=A"}} {
+		if _, err := newSanitizer(pairs); err == nil {
+			t.Errorf("expected an error for %v", pairs)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package networkmanager
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/gliderlabs/resolvable/resolver"
+	"github.com/gliderlabs/resolvable/settings"
+
+	"github.com/godbus/dbus"
+)
+
+const (
+	nmDest = "org.freedesktop.NetworkManager"
+	nmPath = "/org/freedesktop/NetworkManager"
+
+	// Reload flag to restart the DNS plugin, so dnsmasq reads its config dir
+	nmReloadDNSPlugin = uint32(0x4)
+)
+
+// DnsmasqConfig configures the dnsmasq instance run by NetworkManager's
+// dnsmasq DNS plugin to forward the resolvable domains to resolvable.
+type DnsmasqConfig struct {
+	path    string
+	reload  func() error
+	address string
+	info    resolver.ResolverInfo
+	written []byte
+}
+
+func init() {
+	confDir := settings.String("NM_DNSMASQ_PATH", "/tmp/NetworkManager/dnsmasq.d", "NetworkManager dnsmasq config directory")
+	pidFile := settings.String("NM_PID_FILE", "/run/NetworkManager/NetworkManager.pid", "NetworkManager pid file, to reload it when D-Bus is not available")
+	if _, err := os.Stat(confDir); err != nil {
+		log.Printf("networkmanager: disabled, cannot read %s: %s", confDir, err)
+		return
+	}
+	resolver.HostResolverConfigs.Register(&DnsmasqConfig{
+		path:   filepath.Join(confDir, "resolvable.conf"),
+		reload: func() error { return reload(pidFile) },
+	}, "networkmanager")
+}
+
+func (r *DnsmasqConfig) StoreAddress(address string) error {
+	r.address = address
+	return r.write()
+}
+
+func (r *DnsmasqConfig) UpdateInfo(info resolver.ResolverInfo) error {
+	r.info = info
+	if info.Address != "" {
+		r.address = info.Address
+	}
+	return r.write()
+}
+
+// Status checks the config written is still in place.
+func (r *DnsmasqConfig) Status() error {
+	if r.written == nil {
+		return nil
+	}
+	current, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(current, r.written) {
+		return fmt.Errorf("%s was changed", r.path)
+	}
+	return nil
+}
+
+func (r *DnsmasqConfig) Clean() {
+	if r.written == nil {
+		return
+	}
+
+	log.Println("networkmanager: removing", r.path)
+	if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+		log.Println("networkmanager:", err)
+	}
+	if err := r.reload(); err != nil {
+		log.Println("networkmanager:", err)
+	}
+}
+
+// config returns a dnsmasq config with a server line for each domain
+// resolvable serves, e.g. "server=/docker/172.17.42.1".
+func (r *DnsmasqConfig) config() []byte {
+	server := r.address
+	if r.info.Port != 0 && r.info.Port != 53 {
+		server += "#" + strconv.Itoa(r.info.Port)
+	}
+
+	localDomain := r.info.LocalDomain
+	if localDomain == "" {
+		localDomain = "docker"
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# added by resolvable, removed when it stops\n")
+	for _, domain := range append([]string{localDomain}, r.info.Domains...) {
+		fmt.Fprintf(&buf, "server=/%s/%s\n", strings.Trim(domain, "."), server)
+	}
+	return buf.Bytes()
+}
+
+func (r *DnsmasqConfig) write() error {
+	if r.address == "" {
+		return nil
+	}
+
+	config := r.config()
+	if bytes.Equal(config, r.written) {
+		return nil
+	}
+
+	log.Println("networkmanager: generating", r.path)
+	if err := ioutil.WriteFile(r.path, config, 0644); err != nil {
+		return err
+	}
+	r.written = config
+
+	return r.reload()
+}
+
+// reload asks NetworkManager to restart dnsmasq over D-Bus, falling back to
+// sending SIGHUP to the pid in pidFile.
+func reload(pidFile string) error {
+	log.Println("networkmanager: reloading DNS")
+
+	conn, err := dbus.SystemBus()
+	if err == nil {
+		err = conn.Object(nmDest, nmPath).Call(nmDest+".Reload", 0, nmReloadDNSPlugin).Err
+		if err == nil {
+			return nil
+		}
+	}
+	log.Printf("networkmanager: reload over D-Bus failed: %s, sending SIGHUP", err)
+
+	data, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("invalid pid in %s: %s", pidFile, err)
+	}
+	return syscall.Kill(pid, syscall.SIGHUP)
+}
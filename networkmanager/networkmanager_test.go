@@ -0,0 +1,134 @@
+package networkmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gliderlabs/resolvable/resolver"
+)
+
+func tempConfig(t *testing.T) (*DnsmasqConfig, *int, func()) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("could not create temp dir:", err)
+	}
+
+	reloads := 0
+	conf := &DnsmasqConfig{
+		path: filepath.Join(dir, "resolvable.conf"),
+		reload: func() error {
+			reloads++
+			return nil
+		},
+	}
+	return conf, &reloads, func() { os.RemoveAll(dir) }
+}
+
+func assertFileContains(t *testing.T, path, expected string) {
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("could not read '%v': %v", path, err)
+	}
+
+	if string(got) != expected {
+		t.Errorf("expected file %v to be:\n%v\n\nbut got:\n%v", path, expected, string(got))
+	}
+}
+
+const header = "# added by resolvable, removed when it stops\n"
+
+func TestStoreAddress(t *testing.T) {
+	conf, reloads, cleanup := tempConfig(t)
+	defer cleanup()
+
+	if err := conf.StoreAddress("172.17.42.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	assertFileContains(t, conf.path, header+"server=/docker/172.17.42.1\n")
+	if *reloads != 1 {
+		t.Errorf("expected 1 reload, got %d", *reloads)
+	}
+}
+
+func TestUpdateInfo(t *testing.T) {
+	conf, reloads, cleanup := tempConfig(t)
+	defer cleanup()
+
+	if err := conf.StoreAddress("172.17.42.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	info := resolver.ResolverInfo{
+		Address:     "172.17.42.1",
+		Port:        5353,
+		LocalDomain: "docker",
+		Domains:     []string{"consul", "service.example."},
+	}
+	if err := conf.UpdateInfo(info); err != nil {
+		t.Fatal(err)
+	}
+
+	assertFileContains(t, conf.path, header+
+		"server=/docker/172.17.42.1#5353\n"+
+		"server=/consul/172.17.42.1#5353\n"+
+		"server=/service.example/172.17.42.1#5353\n")
+
+	// unchanged info should not reload again
+	if err := conf.UpdateInfo(info); err != nil {
+		t.Fatal(err)
+	}
+	if *reloads != 2 {
+		t.Errorf("expected 2 reloads, got %d", *reloads)
+	}
+}
+
+func TestClean(t *testing.T) {
+	conf, reloads, cleanup := tempConfig(t)
+	defer cleanup()
+
+	if err := conf.StoreAddress("172.17.42.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	conf.Clean()
+
+	if _, err := os.Stat(conf.path); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed, got: %v", conf.path, err)
+	}
+	if *reloads != 2 {
+		t.Errorf("expected 2 reloads, got %d", *reloads)
+	}
+}
+
+func TestCleanNotWritten(t *testing.T) {
+	conf, reloads, cleanup := tempConfig(t)
+	defer cleanup()
+
+	conf.Clean()
+
+	if *reloads != 0 {
+		t.Errorf("expected no reloads, got %d", *reloads)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	conf, _, cleanup := tempConfig(t)
+	defer cleanup()
+
+	if err := conf.StoreAddress("172.17.42.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.Status(); err != nil {
+		t.Error("expected no error, got:", err)
+	}
+
+	if err := os.Remove(conf.path); err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.Status(); err == nil {
+		t.Error("expected an error after the config was removed")
+	}
+}
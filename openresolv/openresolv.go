@@ -0,0 +1,104 @@
+package openresolv
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+
+	"github.com/gliderlabs/resolvable/resolver"
+	"github.com/gliderlabs/resolvable/settings"
+)
+
+// ResolvconfConfig adds resolvable to the host's resolv.conf through the
+// resolvconf(8) command, as provided by openresolv or Debian's resolvconf,
+// instead of editing the file directly.
+type ResolvconfConfig struct {
+	record  string
+	command func(stdin []byte, args ...string) error
+	address string
+	domain  string
+	added   bool
+}
+
+func init() {
+	iface := settings.String("RESOLVCONF_INTERFACE", "", "interface to register resolvable for with resolvconf(8)")
+	command := settings.String("RESOLVCONF_COMMAND", "resolvconf", "path to the resolvconf command")
+	if iface == "" {
+		log.Println("openresolv: disabled, RESOLVCONF_INTERFACE not set")
+		return
+	}
+	resolver.HostResolverConfigs.Register(&ResolvconfConfig{
+		record:  iface + ".resolvable",
+		command: resolvconf(command),
+		domain:  "docker",
+	}, "openresolv")
+}
+
+// resolvconf returns a function running the resolvconf command at path.
+func resolvconf(path string) func(stdin []byte, args ...string) error {
+	return func(stdin []byte, args ...string) error {
+		cmd := exec.Command(path, args...)
+		cmd.Stdin = bytes.NewReader(stdin)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s %v: %s: %s", path, args, err, bytes.TrimSpace(out))
+		}
+		return nil
+	}
+}
+
+func (r *ResolvconfConfig) StoreAddress(address string) error {
+	r.address = address
+	return r.add()
+}
+
+func (r *ResolvconfConfig) UpdateInfo(info resolver.ResolverInfo) error {
+	if info.Address == r.address && info.LocalDomain == r.domain {
+		return nil
+	}
+	if info.Address != "" {
+		r.address = info.Address
+	}
+	if info.LocalDomain != "" {
+		r.domain = info.LocalDomain
+	}
+	return r.add()
+}
+
+// Status reports if adding the record failed.
+func (r *ResolvconfConfig) Status() error {
+	if r.address != "" && !r.added {
+		return fmt.Errorf("%s not added to resolvconf", r.record)
+	}
+	return nil
+}
+
+func (r *ResolvconfConfig) Clean() {
+	if !r.added {
+		return
+	}
+
+	log.Println("openresolv: removing", r.record)
+	if err := r.command(nil, "-d", r.record); err != nil {
+		log.Println("openresolv:", err)
+	}
+}
+
+func (r *ResolvconfConfig) add() error {
+	if r.address == "" {
+		return nil
+	}
+
+	var entry bytes.Buffer
+	fmt.Fprintf(&entry, "nameserver %s\n", r.address)
+	if r.domain != "" {
+		fmt.Fprintf(&entry, "search %s\n", r.domain)
+	}
+
+	log.Println("openresolv: adding", r.record)
+	if err := r.command(entry.Bytes(), "-a", r.record); err != nil {
+		return err
+	}
+	r.added = true
+	return nil
+}
@@ -0,0 +1,85 @@
+package openresolv
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gliderlabs/resolvable/resolver"
+)
+
+type call struct {
+	stdin string
+	args  []string
+}
+
+func fakeConfig() (*ResolvconfConfig, *[]call) {
+	calls := []call{}
+	conf := &ResolvconfConfig{
+		record: "docker0.resolvable",
+		command: func(stdin []byte, args ...string) error {
+			calls = append(calls, call{string(stdin), args})
+			return nil
+		},
+		domain: "docker",
+	}
+	return conf, &calls
+}
+
+func assertCalls(t *testing.T, expected, got []call) {
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected calls:\n%#v\n\nbut got:\n%#v", expected, got)
+	}
+}
+
+func TestStoreAddress(t *testing.T) {
+	conf, calls := fakeConfig()
+
+	if err := conf.StoreAddress("172.17.42.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	assertCalls(t, []call{
+		{"nameserver 172.17.42.1\nsearch docker\n", []string{"-a", "docker0.resolvable"}},
+	}, *calls)
+}
+
+func TestUpdateInfo(t *testing.T) {
+	conf, calls := fakeConfig()
+
+	if err := conf.StoreAddress("172.17.42.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// unchanged info should not update the record again
+	info := resolver.ResolverInfo{Address: "172.17.42.1", LocalDomain: "docker"}
+	if err := conf.UpdateInfo(info); err != nil {
+		t.Fatal(err)
+	}
+
+	info.LocalDomain = "local.test"
+	if err := conf.UpdateInfo(info); err != nil {
+		t.Fatal(err)
+	}
+
+	assertCalls(t, []call{
+		{"nameserver 172.17.42.1\nsearch docker\n", []string{"-a", "docker0.resolvable"}},
+		{"nameserver 172.17.42.1\nsearch local.test\n", []string{"-a", "docker0.resolvable"}},
+	}, *calls)
+}
+
+func TestClean(t *testing.T) {
+	conf, calls := fakeConfig()
+
+	conf.Clean()
+	assertCalls(t, []call{}, *calls)
+
+	if err := conf.StoreAddress("172.17.42.1"); err != nil {
+		t.Fatal(err)
+	}
+	conf.Clean()
+
+	assertCalls(t, []call{
+		{"nameserver 172.17.42.1\nsearch docker\n", []string{"-a", "docker0.resolvable"}},
+		{"", []string{"-d", "docker0.resolvable"}},
+	}, *calls)
+}
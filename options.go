@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gliderlabs/resolvable/api"
+	"github.com/gliderlabs/resolvable/resolver"
+	"github.com/gliderlabs/resolvable/settings"
+)
+
+// options are the settings of resolvable itself, the host modules register
+// their own.
+type options struct {
+	dockerHost string
+
+	listenAddrs         []string
+	listenInterfaces    []string
+	advertiseAddr       string
+	advertiseInterfaces []string
+	port                int
+
+	hostIP        string
+	localDomains  []string
+	nameTemplates []string
+	nameSanitize  bool
+	nameReplace   []string
+	nameRaw       bool
+	nameConflicts string
+	ttl           int
+
+	upstreams  []string
+	resolvConf string
+
+	staticHosts []string
+	staticZones []string
+
+	updateKeys  []string
+	updateLease int
+
+	recursionNets []string
+	allowNets     []string
+	denyNets      []string
+
+	modules []string
+
+	apiAddr string
+
+	logLevel string
+}
+
+func loadOptions() *options {
+	o := &options{
+		dockerHost: settings.String("DOCKER_HOST", "unix:///tmp/docker.sock", "Docker endpoint to follow containers from"),
+
+		listenAddrs:         settings.List("LISTEN_ADDRS", "addresses to listen on, default all IPv4 addresses"),
+		listenInterfaces:    settings.List("LISTEN_INTERFACES", "interfaces whose addresses to listen on"),
+		advertiseAddr:       settings.String("ADVERTISE_ADDR", "", "address to configure the host to use"),
+		advertiseInterfaces: settings.List("ADVERTISE_INTERFACES", "interfaces to pick the address to configure the host to use from"),
+		port:                settings.Int("LISTEN_PORT", 53, "port to listen on"),
+
+		hostIP:        settings.String("HOST_IP", "", "address of containers using the host network, or auto"),
+		localDomains:  domainList(settings.String("LOCAL_DOMAIN", "docker", "domains of the container names, the first is configured as the host's search domain")),
+		nameTemplates: splitList(settings.String("NAME_TEMPLATES", defaultNameTemplates, "templates of the names registered for each container")),
+		nameSanitize:  settings.Bool("NAME_SANITIZE", true, "register names with the container fields turned into valid DNS labels"),
+		nameReplace:   splitList(settings.String("NAME_REPLACE", defaultNameReplace, "characters to replace when sanitizing names, as character=replacement pairs")),
+		nameRaw:       settings.Bool("NAME_RAW", true, "also register names with the container fields as they are"),
+		nameConflicts: settings.String("NAME_CONFLICTS", "merge", "how to answer names registered by several containers: merge, newest, oldest or reject"),
+		ttl:           settings.Int("RECORD_TTL", 0, "TTL of the container records, in seconds"),
+
+		upstreams:  settings.List("UPSTREAMS", "upstream servers as address or address:port, instead of those in UPSTREAM_RESOLV_CONF"),
+		resolvConf: settings.String("UPSTREAM_RESOLV_CONF", "/etc/resolv.conf", "resolv.conf to read upstream servers from"),
+
+		staticHosts: settings.List("STATIC_HOSTS", "/etc/hosts-style files with static records, served in the first local domain too"),
+		staticZones: settings.List("STATIC_ZONES", "RFC 1035 zone files with static A records, relative to the first local domain"),
+
+		updateKeys:  settings.List("UPDATE_KEYS", "TSIG keys as name:base64-secret, to accept dynamic updates of the local domains signed with"),
+		updateLease: settings.Int("UPDATE_LEASE", 0, "seconds after which the hosts added by dynamic updates expire, or 0 for never"),
+
+		recursionNets: settings.List("RECURSION_NETS", "networks allowed to use recursion, or none"),
+		allowNets:     settings.List("ALLOW_NETS", "networks allowed to query, default loopback and the Docker networks, and the host's addresses on the host network"),
+		denyNets:      settings.List("DENY_NETS", "networks denied from querying"),
+
+		modules: settings.List("MODULES", "host modules to enable, default all"),
+
+		apiAddr: settings.String("API_ADDR", "", "unix:PATH or loopback HOST:PORT to serve the HTTP management API on"),
+
+		logLevel: settings.String("LOG_LEVEL", "info", "info, or debug to also log each query"),
+	}
+
+	settings.Check("LISTEN_ADDRS", eachValue(checkAddress))
+	settings.Check("ADVERTISE_ADDR", checkAddress)
+	settings.Check("LISTEN_PORT", checkPort)
+	settings.Check("HOST_IP", func(value string) error {
+		if value == "auto" {
+			return nil
+		}
+		return checkAddress(value)
+	})
+	settings.Required("LOCAL_DOMAIN")
+	settings.Check("LOCAL_DOMAIN", func(value string) error {
+		if len(domainList(value)) == 0 {
+			return errors.New("expected at least one domain")
+		}
+		return eachValue(func(value string) error {
+			return checkName(strings.Trim(value, "."))
+		})(value)
+	})
+	settings.Check("NAME_TEMPLATES", func(value string) error {
+		if len(o.localDomains) == 0 {
+			// reported for LOCAL_DOMAIN
+			return nil
+		}
+		_, err := parseNameTemplates(splitList(value), o.localDomains)
+		return err
+	})
+	settings.Check("NAME_REPLACE", func(value string) error {
+		_, err := newSanitizer(splitList(value))
+		return err
+	})
+	settings.Check("NAME_CONFLICTS", func(value string) error {
+		_, err := resolver.ParseConflictPolicy(value)
+		return err
+	})
+	settings.Check("RECORD_TTL", func(value string) error {
+		if ttl, _ := strconv.Atoi(value); ttl < 0 {
+			return errors.New("must not be negative")
+		}
+		return nil
+	})
+	settings.Check("UPSTREAMS", eachValue(func(value string) error {
+		_, _, err := parseUpstream(value)
+		return err
+	}))
+	settings.Secret("UPDATE_KEYS")
+	settings.Check("UPDATE_KEYS", func(value string) error {
+		_, err := parseUpdateKeys(splitList(value))
+		return err
+	})
+	settings.Check("UPDATE_LEASE", func(value string) error {
+		if lease, _ := strconv.Atoi(value); lease < 0 {
+			return errors.New("must not be negative")
+		}
+		return nil
+	})
+	settings.Check("API_ADDR", func(value string) error {
+		if value == "" {
+			return nil
+		}
+		_, _, err := api.ParseAddress(value)
+		return err
+	})
+	settings.Check("LOG_LEVEL", func(value string) error {
+		if value != "info" && value != "debug" {
+			return errors.New("expected info or debug")
+		}
+		return nil
+	})
+	for _, name := range []string{"RECURSION_NETS", "ALLOW_NETS", "DENY_NETS"} {
+		settings.Check(name, func(value string) error {
+			_, err := parseNetworks(splitList(value), nil)
+			return err
+		})
+	}
+
+	return o
+}
+
+// eachValue checks each value of a comma-separated list.
+func eachValue(check func(string) error) func(string) error {
+	return func(list string) error {
+		for _, value := range splitList(list) {
+			if err := check(value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// domainList splits a list of domains, without leading or trailing dots.
+func domainList(list string) []string {
+	var domains []string
+	for _, domain := range splitList(list) {
+		if domain = strings.Trim(domain, "."); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+func checkAddress(value string) error {
+	if net.ParseIP(value) == nil {
+		return fmt.Errorf("invalid address %q", value)
+	}
+	return nil
+}
+
+func checkPort(value string) error {
+	if port, _ := strconv.Atoi(value); port < 0 || port > 65535 {
+		return errors.New("not a port number")
+	}
+	return nil
+}
+
+// parseNetworks parses a list of networks, where "none" is an empty list, and
+// no networks means the default.
+func parseNetworks(values []string, def []*net.IPNet) ([]*net.IPNet, error) {
+	switch {
+	case len(values) == 0:
+		return def, nil
+	case len(values) == 1 && values[0] == "none":
+		return nil, nil
+	}
+	return resolver.ParseNetworks(values)
+}
+
+// parseUpstream parses an upstream server, as an address with an optional
+// port, e.g. "8.8.8.8" or "[2001:4860:4860::8888]:53".
+func parseUpstream(value string) (net.IP, int, error) {
+	if ip := net.ParseIP(value); ip != nil {
+		return ip, 53, nil
+	}
+
+	host, portString, err := net.SplitHostPort(value)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid upstream %q", value)
+	}
+	ip := net.ParseIP(host)
+	port, err := strconv.Atoi(portString)
+	if ip == nil || err != nil || checkPort(portString) != nil {
+		return nil, 0, fmt.Errorf("invalid upstream %q", value)
+	}
+	return ip, port, nil
+}
+
+// parseUpdateKeys returns the secrets of the TSIG keys given as name:secret,
+// by name.
+func parseUpdateKeys(values []string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, value := range values {
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.New("expected name:secret")
+		}
+		if _, err := base64.StdEncoding.DecodeString(parts[1]); err != nil {
+			return nil, fmt.Errorf("secret of key %s is not base64", parts[0])
+		}
+		keys[parts[0]] = parts[1]
+	}
+	return keys, nil
+}
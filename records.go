@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/resolvable/resolver"
+)
+
+// recordsSettle batches host changes, like the containers registered on
+// start, into one update of the host resolver configs.
+const recordsSettle = 100 * time.Millisecond
+
+// recordsUpdater passes the hosts served on to the host resolver configs
+// implementing resolver.RecordsConfig when they change.
+type recordsUpdater struct {
+	sync.Mutex
+	configs *hostConfigs
+	records func() []resolver.HostRecord
+	timer   *time.Timer
+	last    []resolver.HostRecord
+	stopped bool
+}
+
+// changed schedules an update, unless one is already pending.
+func (u *recordsUpdater) changed() {
+	u.Lock()
+	defer u.Unlock()
+
+	if u.timer == nil && !u.stopped {
+		u.timer = time.AfterFunc(recordsSettle, u.update)
+	}
+}
+
+func (u *recordsUpdater) update() {
+	u.Lock()
+	defer u.Unlock()
+
+	u.timer = nil
+	if u.stopped {
+		return
+	}
+
+	records := u.records()
+	if reflect.DeepEqual(records, u.last) {
+		return
+	}
+	u.last = records
+	u.configs.updateRecords(records)
+}
+
+// stop cancels any pending update.
+func (u *recordsUpdater) stop() {
+	u.Lock()
+	defer u.Unlock()
+
+	u.stopped = true
+	if u.timer != nil {
+		u.timer.Stop()
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/resolvable/resolver"
+	"github.com/gliderlabs/resolvable/settings"
+
+	"github.com/coreos/go-systemd/daemon"
+	"github.com/joeshaw/multierror"
+)
+
+// liveSettings can be applied while running, the others only on restart.
+var liveSettings = map[string]bool{
+	"RECORD_TTL":           true,
+	"NAME_CONFLICTS":       true,
+	"UPSTREAMS":            true,
+	"UPSTREAM_RESOLV_CONF": true,
+	"STATIC_HOSTS":         true,
+	"STATIC_ZONES":         true,
+	"UPDATE_KEYS":          true,
+	"UPDATE_LEASE":         true,
+	"RECURSION_NETS":       true,
+	"ALLOW_NETS":           true,
+	"DENY_NETS":            true,
+	"LOG_LEVEL":            true,
+}
+
+// liveResolver is the part of the resolver that can be configured while
+// running.
+type liveResolver interface {
+	AddUpstream(id string, addr net.IP, port int, domain ...string) error
+	RemoveUpstream(id string) error
+	FollowResolvConf(path string, ignore func(server string) bool) (io.Closer, error)
+	FollowHostsFile(path, domain string) (io.Closer, error)
+	FollowZoneFile(path, origin string) (io.Closer, error)
+
+	SetTTL(ttl uint32)
+	SetConflictPolicy(policy resolver.ConflictPolicy)
+	SetLogQueries(enabled bool)
+	SetRecursionNetworks(nets []*net.IPNet)
+	SetAccessNetworks(allow, deny []*net.IPNet)
+	SetUpdateZones(zones []string, keys map[string]string, lease time.Duration) error
+}
+
+// liveConfig applies the live settings to the resolver, when starting and
+// again on each reload.
+type liveConfig struct {
+	resolver liveResolver
+	// ignore skips servers in resolv.conf, like resolvable itself
+	ignore func(server string) bool
+	// domain of the static hosts
+	domain string
+	// domains accepting dynamic updates
+	updateZones []string
+
+	applied    bool
+	upstreams  []string
+	resolvConf string
+	follower   io.Closer
+	// followers of the static files, by format and path
+	static map[string]io.Closer
+
+	// networks allowed and denied to query, kept to refresh the default
+	// allowed ones when the host's addresses change
+	accessMutex sync.Mutex
+	allowNets   []string
+	denyNets    []string
+}
+
+// apply applies the live settings. They are all parsed first, so that an
+// invalid one changes nothing; the static files and upstreams are then applied
+// even if some of them fail, with the errors returned together.
+func (c *liveConfig) apply(o *options) error {
+	policy, err := resolver.ParseConflictPolicy(o.nameConflicts)
+	if err != nil {
+		return fmt.Errorf("NAME_CONFLICTS: %s", err)
+	}
+	recursionNets, err := parseNetworks(o.recursionNets, resolver.PrivateNetworks())
+	if err != nil {
+		return fmt.Errorf("RECURSION_NETS: %s", err)
+	}
+	allow, deny, err := accessNetworks(o.allowNets, o.denyNets)
+	if err != nil {
+		return err
+	}
+	keys, err := parseUpdateKeys(o.updateKeys)
+	if err != nil {
+		return fmt.Errorf("UPDATE_KEYS: %s", err)
+	}
+	for _, upstream := range o.upstreams {
+		if _, _, err := parseUpstream(upstream); err != nil {
+			return fmt.Errorf("UPSTREAMS: %s", err)
+		}
+	}
+
+	lease := time.Duration(o.updateLease) * time.Second
+	if err := c.resolver.SetUpdateZones(c.updateZones, keys, lease); err != nil {
+		return fmt.Errorf("UPDATE_KEYS: %s", err)
+	}
+	if len(keys) > 0 {
+		log.Printf("accepting dynamic updates of %v, lease: %v", c.updateZones, lease)
+	}
+
+	c.resolver.SetTTL(uint32(o.ttl))
+	c.resolver.SetLogQueries(o.logLevel == "debug")
+	c.resolver.SetConflictPolicy(policy)
+
+	c.resolver.SetRecursionNetworks(recursionNets)
+	if len(o.recursionNets) > 0 {
+		log.Println("allowing recursion for:", o.recursionNets)
+	}
+
+	c.accessMutex.Lock()
+	c.resolver.SetAccessNetworks(allow, deny)
+	c.allowNets, c.denyNets = o.allowNets, o.denyNets
+	c.accessMutex.Unlock()
+	if len(o.allowNets) > 0 || len(o.denyNets) > 0 {
+		log.Printf("allowing queries from: %v, denying: %v", o.allowNets, o.denyNets)
+	}
+
+	var errs multierror.Errors
+	if err := c.applyStatic(o); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.applyUpstreams(o); err != nil {
+		errs = append(errs, err)
+	}
+	return errs.Err()
+}
+
+// accessNetworks parses the networks allowed and denied to query, with the
+// default allowed networks computed from the current interfaces.
+func accessNetworks(allowNets, denyNets []string) (allow, deny []*net.IPNet, err error) {
+	defaultAllow, err := resolver.DefaultAllowNetworks()
+	if err != nil {
+		return nil, nil, err
+	}
+	allow, err = parseNetworks(allowNets, defaultAllow)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ALLOW_NETS: %s", err)
+	}
+	deny, err = parseNetworks(denyNets, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("DENY_NETS: %s", err)
+	}
+	return allow, deny, nil
+}
+
+// refreshAccess recomputes the default allowed networks, so Docker networks
+// created while running, like those of compose projects, can query.
+func (c *liveConfig) refreshAccess() {
+	c.accessMutex.Lock()
+	defer c.accessMutex.Unlock()
+
+	if len(c.allowNets) > 0 {
+		return
+	}
+	allow, deny, err := accessNetworks(c.allowNets, c.denyNets)
+	if err != nil {
+		log.Println("error refreshing the allowed networks:", err)
+		return
+	}
+	c.resolver.SetAccessNetworks(allow, deny)
+}
+
+// applyStatic follows the files in STATIC_HOSTS and STATIC_ZONES, and stops
+// following those no longer listed.
+func (c *liveConfig) applyStatic(o *options) error {
+	files := make(map[string]bool)
+	for _, path := range o.staticHosts {
+		files["hosts:"+path] = true
+	}
+	for _, path := range o.staticZones {
+		files["zone:"+path] = true
+	}
+
+	if c.static == nil {
+		c.static = make(map[string]io.Closer)
+	}
+	for file, follower := range c.static {
+		if !files[file] {
+			follower.Close()
+			delete(c.static, file)
+		}
+	}
+
+	var errs multierror.Errors
+	for file := range files {
+		if _, ok := c.static[file]; ok {
+			continue
+		}
+		var follower io.Closer
+		var err error
+		if path := strings.TrimPrefix(file, "hosts:"); path != file {
+			follower, err = c.resolver.FollowHostsFile(path, c.domain)
+		} else {
+			follower, err = c.resolver.FollowZoneFile(strings.TrimPrefix(file, "zone:"), c.domain)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.static[file] = follower
+	}
+	return errs.Err()
+}
+
+// applyUpstreams forwards to the UPSTREAMS, or follows the servers in
+// UPSTREAM_RESOLV_CONF if there are none, replacing the previous servers.
+func (c *liveConfig) applyUpstreams(o *options) error {
+	if c.applied && reflect.DeepEqual(o.upstreams, c.upstreams) && o.resolvConf == c.resolvConf {
+		return nil
+	}
+
+	c.closeUpstreams()
+	// applied again on the next reload, even if unchanged, if this fails
+	c.applied = false
+	c.upstreams, c.resolvConf = o.upstreams, o.resolvConf
+
+	if len(o.upstreams) > 0 {
+		for _, upstream := range o.upstreams {
+			ip, port, _ := parseUpstream(upstream)
+			c.resolver.AddUpstream("upstream:"+upstream, ip, port)
+		}
+		log.Println("forwarding to:", o.upstreams)
+	} else {
+		follower, err := c.resolver.FollowResolvConf(o.resolvConf, c.ignore)
+		if err != nil {
+			return err
+		}
+		c.follower = follower
+	}
+
+	c.applied = true
+	return nil
+}
+
+// close removes the upstream servers and static hosts added by the config.
+func (c *liveConfig) close() {
+	c.closeUpstreams()
+	for file, follower := range c.static {
+		follower.Close()
+		delete(c.static, file)
+	}
+}
+
+func (c *liveConfig) closeUpstreams() {
+	for _, upstream := range c.upstreams {
+		c.resolver.RemoveUpstream("upstream:" + upstream)
+	}
+	if c.follower != nil {
+		c.follower.Close()
+		c.follower = nil
+	}
+}
+
+// reloadingState is the notification of a reload starting, with the time it
+// started, which Type=notify-reload expects to match the reload to its SIGHUP.
+func reloadingState() string {
+	if usec, ok := monotonicUsec(); ok {
+		return fmt.Sprintf("RELOADING=1\nMONOTONIC_USEC=%d", usec)
+	}
+	return "RELOADING=1"
+}
+
+// reloadConfig reads the config file again, logs what changed and applies the
+// live settings. An invalid config is rejected, and the current one kept.
+func reloadConfig(c *liveConfig) {
+	// systemd shows the unit as reloading until it is ready again, even if
+	// the reload fails and the current config is kept
+	daemon.SdNotify(reloadingState())
+	defer daemon.SdNotify("READY=1")
+
+	changes, err := settings.Reload()
+	if err != nil {
+		log.Println("[ERROR] invalid config, keeping the current one:", err)
+		return
+	}
+	if len(changes) == 0 {
+		log.Println("config reloaded, nothing changed")
+		return
+	}
+
+	for _, change := range changes {
+		if liveSettings[change.Name] {
+			log.Printf("config changed: %s: %q -> %q", change.Name, change.Old, change.New)
+		} else {
+			log.Printf("config changed: %s: %q -> %q, restart to apply", change.Name, change.Old, change.New)
+		}
+	}
+
+	if err := c.apply(loadOptions()); err != nil {
+		log.Println("[ERROR] applying config:", err)
+	}
+}
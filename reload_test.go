@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/resolvable/resolver"
+)
+
+// fakeLiveResolver records the settings applied to it.
+type fakeLiveResolver struct {
+	liveResolver
+	applied []string
+}
+
+func (r *fakeLiveResolver) SetTTL(ttl uint32) {
+	r.applied = append(r.applied, "ttl")
+}
+
+func (r *fakeLiveResolver) SetLogQueries(enabled bool) {
+	r.applied = append(r.applied, "log")
+}
+
+func (r *fakeLiveResolver) SetConflictPolicy(policy resolver.ConflictPolicy) {
+	r.applied = append(r.applied, "conflicts")
+}
+
+func (r *fakeLiveResolver) SetRecursionNetworks(nets []*net.IPNet) {
+	r.applied = append(r.applied, "recursion")
+}
+
+func (r *fakeLiveResolver) SetAccessNetworks(allow, deny []*net.IPNet) {
+	r.applied = append(r.applied, "access")
+}
+
+func (r *fakeLiveResolver) SetUpdateZones(zones []string, keys map[string]string, lease time.Duration) error {
+	r.applied = append(r.applied, "update")
+	return nil
+}
+
+func (r *fakeLiveResolver) FollowHostsFile(path, domain string) (io.Closer, error) {
+	return nil, errors.New("cannot read " + path)
+}
+
+func (r *fakeLiveResolver) AddUpstream(id string, addr net.IP, port int, domain ...string) error {
+	r.applied = append(r.applied, id)
+	return nil
+}
+
+func liveOptions() *options {
+	return &options{nameConflicts: "merge", logLevel: "info"}
+}
+
+func TestApplyInvalid(t *testing.T) {
+	fake := &fakeLiveResolver{}
+	live := &liveConfig{resolver: fake}
+
+	o := liveOptions()
+	o.upstreams = []string{"8.8.8.8", "not an address"}
+	if err := live.apply(o); err == nil {
+		t.Fatal("expected an error for an invalid upstream")
+	}
+	equals(t, []string(nil), fake.applied)
+
+	o = liveOptions()
+	o.nameConflicts = "unknown"
+	if err := live.apply(o); err == nil {
+		t.Fatal("expected an error for an invalid conflict policy")
+	}
+	equals(t, []string(nil), fake.applied)
+}
+
+func TestApplyStaticError(t *testing.T) {
+	fake := &fakeLiveResolver{}
+	live := &liveConfig{resolver: fake}
+
+	// the upstreams are applied even if a static file can't be read
+	o := liveOptions()
+	o.staticHosts = []string{"/nonexistent/hosts"}
+	o.upstreams = []string{"8.8.8.8"}
+	if err := live.apply(o); err == nil {
+		t.Fatal("expected an error for the static file")
+	}
+	equals(t, []string{"update", "ttl", "log", "conflicts", "recursion", "access", "upstream:8.8.8.8"}, fake.applied)
+}
+
+func TestReloadingState(t *testing.T) {
+	state := reloadingState()
+	if _, ok := monotonicUsec(); ok && !strings.HasPrefix(state, "RELOADING=1\nMONOTONIC_USEC=") {
+		t.Errorf("expected the reload to be sent with its time, got %q", state)
+	}
+}
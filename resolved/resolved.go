@@ -0,0 +1,169 @@
+package resolved
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/gliderlabs/resolvable/resolver"
+	"github.com/gliderlabs/resolvable/settings"
+
+	"github.com/godbus/dbus"
+)
+
+const (
+	resolvedDest    = "org.freedesktop.resolve1"
+	resolvedPath    = "/org/freedesktop/resolve1"
+	resolvedManager = "org.freedesktop.resolve1.Manager"
+)
+
+// linkDNS, linkDNSEx and linkDomain are marshalled as the a(iay), a(iayqs)
+// and a(sb) arguments of SetLinkDNS, SetLinkDNSEx and SetLinkDomains.
+type linkDNS struct {
+	Family  int32
+	Address []byte
+}
+
+type linkDNSEx struct {
+	Family  int32
+	Address []byte
+	Port    uint16
+	Name    string
+}
+
+type linkDomain struct {
+	Domain      string
+	RoutingOnly bool
+}
+
+// ResolvedConfig configures systemd-resolved over D-Bus to send queries for
+// the resolvable domains to resolvable, by setting it as the DNS server of the
+// Docker bridge link with those domains as routing-only domains. Other
+// queries keep using the host's usual DNS servers.
+type ResolvedConfig struct {
+	iface   string
+	call    func(method string, args ...interface{}) error
+	address string
+	port    int
+	info    resolver.ResolverInfo
+	changed bool
+}
+
+func init() {
+	iface := settings.String("RESOLVED_INTERFACE", "", "bridge interface to route the resolvable domains to with systemd-resolved")
+	if iface == "" {
+		log.Println("resolved: disabled, RESOLVED_INTERFACE not set")
+		return
+	}
+	resolver.HostResolverConfigs.Register(&ResolvedConfig{iface: iface, call: call}, "resolved")
+}
+
+func (r *ResolvedConfig) StoreAddress(address string) error {
+	r.address = address
+	return r.setLinkDNS()
+}
+
+func (r *ResolvedConfig) UpdateInfo(info resolver.ResolverInfo) error {
+	r.info = info
+	if info.Address != "" && (info.Address != r.address || dnsPort(info.Port) != dnsPort(r.port)) {
+		r.address = info.Address
+		r.port = info.Port
+		if err := r.setLinkDNS(); err != nil {
+			return err
+		}
+	}
+	return r.setLinkDomains()
+}
+
+func (r *ResolvedConfig) Clean() {
+	if !r.changed {
+		return
+	}
+
+	ifindex, err := r.ifindex()
+	if err == nil {
+		log.Printf("resolved: %s: reverting link", r.iface)
+		err = r.call("RevertLink", ifindex)
+	}
+	if err != nil {
+		log.Println("resolved:", err)
+	}
+}
+
+// setLinkDNS sets resolvable as the DNS server of the link. A port other than
+// 53 needs SetLinkDNSEx, which older systemd versions don't have.
+func (r *ResolvedConfig) setLinkDNS() error {
+	ip := net.ParseIP(r.address)
+	if ip == nil {
+		return fmt.Errorf("invalid address %q", r.address)
+	}
+	port := dnsPort(r.port)
+	if port < 0 || port > 65535 {
+		return fmt.Errorf("invalid port %d", port)
+	}
+
+	family, address := int32(syscall.AF_INET6), ip.To16()
+	if ipv4 := ip.To4(); ipv4 != nil {
+		family, address = syscall.AF_INET, ipv4
+	}
+
+	ifindex, err := r.ifindex()
+	if err != nil {
+		return err
+	}
+
+	r.changed = true
+	if port == 53 {
+		log.Printf("resolved: %s: setting DNS server %s", r.iface, ip)
+		return r.call("SetLinkDNS", ifindex, []linkDNS{{Family: family, Address: address}})
+	}
+	log.Printf("resolved: %s: setting DNS server %s", r.iface, net.JoinHostPort(ip.String(), fmt.Sprint(port)))
+	return r.call("SetLinkDNSEx", ifindex, []linkDNSEx{{Family: family, Address: address, Port: uint16(port)}})
+}
+
+// dnsPort returns the port resolvable listens on, 53 unless known otherwise.
+func dnsPort(port int) int {
+	if port == 0 {
+		return 53
+	}
+	return port
+}
+
+func (r *ResolvedConfig) setLinkDomains() error {
+	var domains []linkDomain
+	var names []string
+	for _, domain := range append([]string{r.info.LocalDomain}, r.info.Domains...) {
+		if domain != "" {
+			domains = append(domains, linkDomain{Domain: domain, RoutingOnly: true})
+			names = append(names, "~"+domain)
+		}
+	}
+
+	ifindex, err := r.ifindex()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("resolved: %s: setting routing domains %s", r.iface, strings.Join(names, " "))
+	r.changed = true
+	return r.call("SetLinkDomains", ifindex, domains)
+}
+
+func (r *ResolvedConfig) ifindex() (int32, error) {
+	iface, err := net.InterfaceByName(r.iface)
+	if err != nil {
+		return 0, err
+	}
+	return int32(iface.Index), nil
+}
+
+// call calls a method of systemd-resolved's manager on the system bus.
+func call(method string, args ...interface{}) error {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return err
+	}
+	return conn.Object(resolvedDest, resolvedPath).Call(resolvedManager+"."+method, 0, args...).Err
+}
@@ -0,0 +1,109 @@
+package resolved
+
+import (
+	"net"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/gliderlabs/resolvable/resolver"
+)
+
+type busCall struct {
+	method string
+	args   []interface{}
+}
+
+func fakeConfig(t *testing.T) (*ResolvedConfig, int32, *[]busCall) {
+	iface, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("no loopback interface:", err)
+	}
+
+	calls := []busCall{}
+	conf := &ResolvedConfig{
+		iface: "lo",
+		call: func(method string, args ...interface{}) error {
+			calls = append(calls, busCall{method, args})
+			return nil
+		},
+	}
+	return conf, int32(iface.Index), &calls
+}
+
+func assertCalls(t *testing.T, expected, got []busCall) {
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected calls:\n%#v\n\nbut got:\n%#v", expected, got)
+	}
+}
+
+func TestStoreAddress(t *testing.T) {
+	conf, ifindex, calls := fakeConfig(t)
+
+	if err := conf.StoreAddress("172.17.42.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	assertCalls(t, []busCall{
+		{"SetLinkDNS", []interface{}{ifindex, []linkDNS{{Family: syscall.AF_INET, Address: []byte{172, 17, 42, 1}}}}},
+	}, *calls)
+}
+
+func TestUpdateInfo(t *testing.T) {
+	conf, ifindex, calls := fakeConfig(t)
+
+	if err := conf.StoreAddress("172.17.42.1"); err != nil {
+		t.Fatal(err)
+	}
+	info := resolver.ResolverInfo{
+		Address:     "172.17.42.1",
+		Port:        53,
+		LocalDomain: "docker",
+		Domains:     []string{"consul"},
+	}
+	if err := conf.UpdateInfo(info); err != nil {
+		t.Fatal(err)
+	}
+
+	domains := []linkDomain{{Domain: "docker", RoutingOnly: true}, {Domain: "consul", RoutingOnly: true}}
+	assertCalls(t, []busCall{
+		{"SetLinkDNS", []interface{}{ifindex, []linkDNS{{Family: syscall.AF_INET, Address: []byte{172, 17, 42, 1}}}}},
+		{"SetLinkDomains", []interface{}{ifindex, domains}},
+	}, *calls)
+
+	// the address is only set again when it changes
+	*calls = nil
+	if err := conf.UpdateInfo(info); err != nil {
+		t.Fatal(err)
+	}
+	assertCalls(t, []busCall{{"SetLinkDomains", []interface{}{ifindex, domains}}}, *calls)
+}
+
+func TestUpdateInfoPort(t *testing.T) {
+	conf, ifindex, calls := fakeConfig(t)
+
+	info := resolver.ResolverInfo{Address: "fd00::1", Port: 5353, LocalDomain: "docker"}
+	if err := conf.UpdateInfo(info); err != nil {
+		t.Fatal(err)
+	}
+
+	assertCalls(t, []busCall{
+		{"SetLinkDNSEx", []interface{}{ifindex, []linkDNSEx{{Family: syscall.AF_INET6, Address: net.ParseIP("fd00::1"), Port: 5353}}}},
+		{"SetLinkDomains", []interface{}{ifindex, []linkDomain{{Domain: "docker", RoutingOnly: true}}}},
+	}, *calls)
+}
+
+func TestClean(t *testing.T) {
+	conf, ifindex, calls := fakeConfig(t)
+
+	// nothing to revert before the link is changed
+	conf.Clean()
+	assertCalls(t, []busCall{}, *calls)
+
+	if err := conf.StoreAddress("172.17.42.1"); err != nil {
+		t.Fatal(err)
+	}
+	*calls = nil
+	conf.Clean()
+	assertCalls(t, []busCall{{"RevertLink", []interface{}{ifindex}}}, *calls)
+}
@@ -0,0 +1,147 @@
+package resolver
+
+import (
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const refusedLogInterval = time.Minute
+
+// dockerBridgePrefixes are the prefixes of the names of the bridges created
+// by Docker: docker0, docker_gwbridge and br-ID for user-defined networks.
+var dockerBridgePrefixes = []string{"docker", "br-"}
+
+// DefaultAllowNetworks returns the networks allowed to query the resolver by
+// default: loopback, and the subnets of the Docker networks. On the host
+// network, those are the subnets of the Docker bridges, and the host's other
+// addresses are allowed but not the rest of the networks they are in.
+func DefaultAllowNetworks() ([]*net.IPNet, error) {
+	nets, err := ParseNetworks([]string{"127.0.0.0/8", "::1/128"})
+	if err != nil {
+		return nil, err
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	addrs := make(map[string][]net.Addr)
+	for _, iface := range ifaces {
+		names = append(names, iface.Name)
+		if addrs[iface.Name], err = iface.Addrs(); err != nil {
+			return nil, err
+		}
+	}
+
+	return append(nets, interfaceAllowNetworks(names, addrs)...), nil
+}
+
+// interfaceAllowNetworks returns the networks to allow for the addresses of
+// the named interfaces. Without any Docker bridge among them, resolvable runs
+// in a container, whose interfaces are on the Docker networks the other
+// containers and the host query it from, so their whole subnets are allowed.
+// Otherwise only the subnets of the bridges are, and each other address.
+func interfaceAllowNetworks(names []string, addrs map[string][]net.Addr) []*net.IPNet {
+	onHost := false
+	for _, name := range names {
+		onHost = onHost || dockerBridge(name)
+	}
+
+	var nets []*net.IPNet
+	for _, name := range names {
+		for _, address := range addrs[name] {
+			ipnet, ok := address.(*net.IPNet)
+			if !ok || ipnet.IP.IsLoopback() {
+				continue
+			}
+			if !onHost || dockerBridge(name) {
+				nets = append(nets, &net.IPNet{IP: ipnet.IP.Mask(ipnet.Mask), Mask: ipnet.Mask})
+			} else {
+				nets = append(nets, hostNetwork(ipnet.IP))
+			}
+		}
+	}
+	return nets
+}
+
+func dockerBridge(name string) bool {
+	for _, prefix := range dockerBridgePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAccessNetworks sets which clients may query the resolver. A client must
+// be within one of the allowed networks, and not within any denied network.
+func (r *dnsResolver) SetAccessNetworks(allow, deny []*net.IPNet) {
+	r.accessMutex.Lock()
+	defer r.accessMutex.Unlock()
+
+	r.allowNets = allow
+	r.denyNets = deny
+}
+
+func (r *dnsResolver) clientAllowed(client net.IP) bool {
+	r.accessMutex.RLock()
+	defer r.accessMutex.RUnlock()
+
+	return client != nil && networksContain(r.allowNets, client) && !networksContain(r.denyNets, client)
+}
+
+func (r *dnsResolver) logRefused(client net.IP, name string) {
+	if ok, suppressed := r.refusedLog.allow(client.String()); ok {
+		if suppressed > 0 {
+			log.Printf("refused query for %s from %s (%d more refused since last logged)", name, client, suppressed)
+		} else {
+			log.Printf("refused query for %s from %s", name, client)
+		}
+	}
+}
+
+// logLimiter allows logging at most one message per key and interval, and
+// counts how many were suppressed in between.
+type logLimiter struct {
+	sync.Mutex
+	interval   time.Duration
+	last       map[string]time.Time
+	suppressed map[string]int
+}
+
+func newLogLimiter(interval time.Duration) *logLimiter {
+	return &logLimiter{
+		interval:   interval,
+		last:       make(map[string]time.Time),
+		suppressed: make(map[string]int),
+	}
+}
+
+func (l *logLimiter) allow(key string) (ok bool, suppressed int) {
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+	if last, found := l.last[key]; found && now.Sub(last) < l.interval {
+		l.suppressed[key]++
+		return false, 0
+	}
+	suppressed = l.suppressed[key]
+
+	// forget keys that haven't been seen for a while, so a scan from many
+	// addresses can't grow the maps without bound
+	for k, last := range l.last {
+		if now.Sub(last) >= l.interval {
+			delete(l.last, k)
+			delete(l.suppressed, k)
+		}
+	}
+
+	delete(l.suppressed, key)
+	l.last[key] = now
+	return true, suppressed
+}
@@ -0,0 +1,128 @@
+package resolver
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tonnerre/golang-dns"
+)
+
+func TestAccessDenied(t *testing.T) {
+	resolver, err := runResolver()
+	ok(t, err)
+	defer resolver.Close()
+
+	resolver.AddHost("foobar", net.ParseIP("1.2.3.4"), "foobar")
+
+	deny, err := ParseNetworks([]string{"127.0.0.1"})
+	ok(t, err)
+	resolver.SetAccessNetworks(PrivateNetworks(), deny)
+
+	r, err := exchange("foobar", dns.TypeA, true, resolver.Port)
+	ok(t, err)
+	equals(t, dns.RcodeRefused, r.Rcode)
+	equals(t, 0, len(r.Answer))
+}
+
+func TestAccessNotAllowed(t *testing.T) {
+	resolver, err := runResolver()
+	ok(t, err)
+	defer resolver.Close()
+
+	resolver.AddHost("foobar", net.ParseIP("1.2.3.4"), "foobar")
+
+	allow, err := ParseNetworks([]string{"10.0.0.0/8"})
+	ok(t, err)
+	resolver.SetAccessNetworks(allow, nil)
+
+	r, err := exchange("foobar", dns.TypeA, true, resolver.Port)
+	ok(t, err)
+	equals(t, dns.RcodeRefused, r.Rcode)
+
+	allow, err = ParseNetworks([]string{"127.0.0.0/8"})
+	ok(t, err)
+	resolver.SetAccessNetworks(allow, nil)
+
+	assertResolvesTo(t, []net.IP{net.ParseIP("1.2.3.4")}, "foobar", resolver.Port)
+}
+
+func TestDefaultAllowNetworks(t *testing.T) {
+	nets, err := DefaultAllowNetworks()
+	ok(t, err)
+
+	if !networksContain(nets, net.ParseIP("127.0.0.1")) {
+		t.Error("expected loopback to be allowed")
+	}
+	if networksContain(nets, net.ParseIP("8.8.8.8")) {
+		t.Error("expected public addresses not to be allowed")
+	}
+}
+
+func TestLogLimiter(t *testing.T) {
+	limiter := newLogLimiter(time.Second / 10)
+
+	allowed, suppressed := limiter.allow("a")
+	equals(t, true, allowed)
+	equals(t, 0, suppressed)
+
+	allowed, _ = limiter.allow("a")
+	equals(t, false, allowed)
+	allowed, _ = limiter.allow("a")
+	equals(t, false, allowed)
+
+	allowed, _ = limiter.allow("b")
+	equals(t, true, allowed)
+
+	time.Sleep(time.Second / 10)
+
+	allowed, suppressed = limiter.allow("a")
+	equals(t, true, allowed)
+	equals(t, 2, suppressed)
+}
+
+func TestInterfaceAllowNetworks(t *testing.T) {
+	addrs := func(specs ...string) []net.Addr {
+		var addrs []net.Addr
+		for _, spec := range specs {
+			ip, ipnet, err := net.ParseCIDR(spec)
+			ok(t, err)
+			addrs = append(addrs, &net.IPNet{IP: ip, Mask: ipnet.Mask})
+		}
+		return addrs
+	}
+
+	// on the host network, the whole subnet of the Docker bridges
+	for _, name := range []string{"docker0", "docker_gwbridge", "br-0123456789ab"} {
+		nets := interfaceAllowNetworks([]string{"lo", name}, map[string][]net.Addr{
+			"lo": addrs("127.0.0.1/8"),
+			name: addrs("172.18.0.1/16"),
+		})
+		equals(t, []string{"172.18.0.0/16"}, networkStrings(nets))
+	}
+
+	// but only the address of the host on other networks, however large
+	nets := interfaceAllowNetworks([]string{"wlan0", "docker0"}, map[string][]net.Addr{
+		"wlan0":   addrs("10.1.2.3/8", "fe80::1/64"),
+		"docker0": addrs("172.17.0.1/16"),
+	})
+	equals(t, []string{"10.1.2.3/32", "fe80::1/128", "172.17.0.0/16"}, networkStrings(nets))
+
+	// in a container, the subnets of the Docker networks it's attached to
+	nets = interfaceAllowNetworks([]string{"lo", "eth0"}, map[string][]net.Addr{
+		"lo":   addrs("127.0.0.1/8"),
+		"eth0": addrs("172.17.0.2/16"),
+	})
+	equals(t, []string{"172.17.0.0/16"}, networkStrings(nets))
+	if !networksContain(nets, net.ParseIP("172.17.0.1")) || !networksContain(nets, net.ParseIP("172.17.0.3")) {
+		t.Error("expected the host and the other containers to be allowed")
+	}
+}
+
+func networkStrings(nets []*net.IPNet) []string {
+	var strs []string
+	for _, ipnet := range nets {
+		strs = append(strs, ipnet.String())
+	}
+	return strs
+}
@@ -0,0 +1,76 @@
+package resolver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic replaces the contents of path with data by writing a
+// temporary file next to it and renaming it into place, so readers never see
+// a partially written file. Files that can't be replaced by a rename, such as
+// bind mounts into a container, are rewritten in place instead. A symlink,
+// like /etc/resolv.conf linking to the systemd-resolved stub, is kept: its
+// target is replaced, or rewritten in place if it can't be resolved.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	} else if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return writeInPlace(path, data, perm)
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	if err := writeAndRename(path, data, perm); err == nil {
+		return nil
+	}
+
+	return writeInPlace(path, data, perm)
+}
+
+func writeAndRename(path string, data []byte, perm os.FileMode) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+name+".resolvable-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func writeInPlace(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, perm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = f.WriteAt(data, 0); err != nil {
+		return err
+	}
+	// contents may have been shortened, so truncate after the new data
+	if err = f.Truncate(int64(len(data))); err != nil {
+		return err
+	}
+	return f.Sync()
+}
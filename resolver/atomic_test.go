@@ -0,0 +1,101 @@
+package resolver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomicNewFile(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.txt")
+	if err := WriteFileAtomic(path, []byte("hello\n"), 0640); err != nil {
+		t.Fatal("could not write file:", err)
+	}
+
+	assertFileContains(t, path, "hello\n")
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("expected mode 0640, got %v", info.Mode().Perm())
+	}
+}
+
+func TestWriteFileAtomicKeepsMode(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.txt")
+	if err := ioutil.WriteFile(path, []byte("a much longer original text\n"), 0600); err != nil {
+		t.Fatal("could not create file:", err)
+	}
+	os.Chmod(path, 0600)
+
+	if err := WriteFileAtomic(path, []byte("short\n"), 0644); err != nil {
+		t.Fatal("could not write file:", err)
+	}
+
+	assertFileContains(t, path, "short\n")
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	// no temporary files should be left behind
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected only %s in %s, got %d files", path, dir, len(files))
+	}
+}
+
+func TestWriteFileAtomicSymlink(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "stub-resolv.conf")
+	if err := ioutil.WriteFile(target, []byte("nameserver 127.0.0.53\n"), 0644); err != nil {
+		t.Fatal("could not create file:", err)
+	}
+	path := filepath.Join(dir, "resolv.conf")
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatal("could not create symlink:", err)
+	}
+
+	if err := WriteFileAtomic(path, []byte("nameserver 172.17.0.1\n"), 0644); err != nil {
+		t.Fatal("could not write file:", err)
+	}
+
+	assertFileContains(t, target, "nameserver 172.17.0.1\n")
+	if info, err := os.Lstat(path); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Error("expected the symlink to be kept")
+	}
+}
+
+func TestWriteInPlace(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.txt")
+	if err := ioutil.WriteFile(path, []byte("a much longer original text\n"), 0644); err != nil {
+		t.Fatal("could not create file:", err)
+	}
+
+	if err := writeInPlace(path, []byte("short\n"), 0644); err != nil {
+		t.Fatal("could not write file:", err)
+	}
+
+	assertFileContains(t, path, "short\n")
+}
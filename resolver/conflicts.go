@@ -0,0 +1,218 @@
+package resolver
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// ConflictPolicy decides which hosts answer for a name registered by several
+// of them. A host marked with SetPreferred wins over the others whatever the
+// policy.
+type ConflictPolicy string
+
+const (
+	// ConflictMerge answers with the addresses of all of them, rotating
+	// which comes first.
+	ConflictMerge ConflictPolicy = "merge"
+	// ConflictNewest answers with the host added last.
+	ConflictNewest ConflictPolicy = "newest"
+	// ConflictOldest answers with the host added first.
+	ConflictOldest ConflictPolicy = "oldest"
+	// ConflictReject doesn't register names for a host that other hosts
+	// already registered, until they are removed.
+	ConflictReject ConflictPolicy = "reject"
+)
+
+// ParseConflictPolicy returns the policy named value.
+func ParseConflictPolicy(value string) (ConflictPolicy, error) {
+	for _, policy := range []ConflictPolicy{ConflictMerge, ConflictNewest, ConflictOldest, ConflictReject} {
+		if string(policy) == value {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("unknown conflict policy %q, expected merge, newest, oldest or reject", value)
+}
+
+// SetConflictPolicy sets how names registered by several hosts are answered.
+// ConflictReject only applies to the hosts added afterwards.
+func (r *dnsResolver) SetConflictPolicy(policy ConflictPolicy) {
+	r.hostMutex.Lock()
+	defer r.hostMutex.Unlock()
+
+	r.conflicts = policy
+}
+
+func (r *dnsResolver) SetPreferred(id string, preferred bool) error {
+	r.hostMutex.Lock()
+	defer r.hostMutex.Unlock()
+
+	if preferred {
+		r.preferred[id] = true
+	} else {
+		delete(r.preferred, id)
+	}
+	return nil
+}
+
+// Conflicts describes the names registered by more than one host, and the
+// names rejected for a host since another had already registered them.
+func (r *dnsResolver) Conflicts() []string {
+	r.hostMutex.RLock()
+	defer r.hostMutex.RUnlock()
+
+	var conflicts []string
+	for _, entry := range r.hosts.hosts {
+		for _, name := range entry.Rejected {
+			conflicts = append(conflicts, fmt.Sprintf("%s rejected for %s", name, shortID(entry.id)))
+		}
+	}
+
+	for name := range r.hosts.names {
+		entries := r.hosts.byName(name)
+		if len(entries) < 2 {
+			continue
+		}
+		sortHosts(entries)
+		var ids []string
+		for _, entry := range entries {
+			ids = append(ids, shortID(entry.id))
+		}
+		conflicts = append(conflicts, fmt.Sprintf("%s registered by %s", strings.TrimSuffix(name, "."), strings.Join(ids, ", ")))
+	}
+
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// checkConflicts logs the names of a host being added that other hosts
+// already registered, and removes them from it for ConflictReject unless it is
+// preferred. The caller must hold hostMutex for writing.
+func (r *dnsResolver) checkConflicts(entry *hostsEntry) {
+	// a host added again only logs conflicts with names it didn't have
+	known := make(map[string]bool)
+	if existing, ok := r.hosts.get(entry.id); ok {
+		for _, name := range append(existing.Names, existing.Rejected...) {
+			known[strings.ToLower(name)] = true
+		}
+	}
+
+	var names []string
+	for _, name := range entry.Names {
+		owner := r.owner(name, entry.id)
+		if owner == nil {
+			names = append(names, name)
+			continue
+		}
+
+		if r.conflicts == ConflictReject && (!r.preferred[entry.id] || r.preferred[owner.id]) {
+			if !known[strings.ToLower(name)] {
+				log.Printf("name conflict: not registering %s for %s, already registered by %s", name, shortID(entry.id), shortID(owner.id))
+			}
+			entry.Rejected = append(entry.Rejected, name)
+			continue
+		}
+		if !known[strings.ToLower(name)] {
+			log.Printf("name conflict: %s registered by %s and %s, answering with policy %s", name, shortID(owner.id), shortID(entry.id), r.conflicts)
+		}
+		names = append(names, name)
+	}
+	entry.Names = names
+}
+
+// promoteRejected registers the names no longer registered by any host, e.g.
+// once their owner is removed, for the host added first that had them
+// rejected. The caller must hold hostMutex for writing.
+func (r *dnsResolver) promoteRejected(names []string) {
+	if r.conflicts != ConflictReject || len(r.hosts.rejected) == 0 {
+		return
+	}
+
+	for _, name := range names {
+		claimants := r.hosts.byRejected(name)
+		if len(claimants) == 0 || len(r.hosts.byName(name)) > 0 {
+			continue
+		}
+		sortHosts(claimants)
+
+		// the store indexes the names, so the host is replaced with a copy
+		entry := *claimants[0]
+		i := rejectedIndex(&entry, name)
+		registered := entry.Rejected[i]
+		entry.Rejected = append(append([]string{}, entry.Rejected[:i]...), entry.Rejected[i+1:]...)
+		entry.Names = append(append([]string{}, entry.Names...), registered)
+		r.hosts.add(&entry)
+		log.Printf("name conflict: registering %s for %s, no longer registered by another host", registered, shortID(entry.id))
+	}
+}
+
+// rejectedIndex returns the index of name in the names rejected for a host,
+// or -1.
+func rejectedIndex(entry *hostsEntry, name string) int {
+	for i, rejected := range entry.Rejected {
+		if strings.EqualFold(rejected, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// owner returns the first host other than id that registered name, if any.
+func (r *dnsResolver) owner(name, id string) *hostsEntry {
+	entries := r.hosts.byName(name)
+	sortHosts(entries)
+	for _, entry := range entries {
+		if entry.id != id {
+			return entry
+		}
+	}
+	return nil
+}
+
+// resolveConflict returns the hosts that answer for a name registered by the
+// given hosts. The caller must hold hostMutex.
+func (r *dnsResolver) resolveConflict(entries []*hostsEntry) []*hostsEntry {
+	if len(entries) < 2 {
+		return entries
+	}
+
+	var preferred []*hostsEntry
+	for _, entry := range entries {
+		if r.preferred[entry.id] {
+			preferred = append(preferred, entry)
+		}
+	}
+	if len(preferred) > 0 {
+		entries = preferred
+	}
+	if len(entries) < 2 {
+		return entries
+	}
+
+	sortHosts(entries)
+	switch r.conflicts {
+	case ConflictNewest:
+		return entries[len(entries)-1:]
+	case ConflictOldest:
+		return entries[:1]
+	}
+
+	// each host is answered first in turn
+	first := int(atomic.AddUint32(&r.rotation, 1) % uint32(len(entries)))
+	return append(append([]*hostsEntry{}, entries[first:]...), entries[:first]...)
+}
+
+// sortHosts sorts hosts in the order they were first added.
+func sortHosts(hosts []*hostsEntry) {
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].seq < hosts[j].seq })
+}
+
+// shortID shortens container ids for logging.
+func shortID(id string) string {
+	if len(id) > 12 && !strings.Contains(id, ":") {
+		return id[:12]
+	}
+	return id
+}
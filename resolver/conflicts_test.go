@@ -0,0 +1,100 @@
+package resolver
+
+import (
+	"net"
+	"testing"
+)
+
+func conflictingResolver(t *testing.T, policy ConflictPolicy) *dnsResolver {
+	resolver, err := NewResolver()
+	ok(t, err)
+	resolver.SetConflictPolicy(policy)
+
+	resolver.AddHost("old", net.ParseIP("10.0.0.1"), "web", "old.docker")
+	resolver.AddHost("new", net.ParseIP("10.0.0.2"), "web", "new.docker")
+	return resolver
+}
+
+func TestConflictMerge(t *testing.T) {
+	resolver := conflictingResolver(t, ConflictMerge)
+
+	first := resolver.findHost("web.")
+	equals(t, 2, len(first))
+	second := resolver.findHost("web.")
+	equals(t, []net.IP{first[1], first[0]}, second)
+
+	equals(t, []string{"web registered by old, new"}, resolver.Conflicts())
+}
+
+func TestConflictNewestOldest(t *testing.T) {
+	resolver := conflictingResolver(t, ConflictNewest)
+	equals(t, []net.IP{net.ParseIP("10.0.0.2")}, resolver.findHost("web."))
+
+	// a host added again keeps its age
+	resolver.AddHost("old", net.ParseIP("10.0.0.3"), "web")
+	equals(t, []net.IP{net.ParseIP("10.0.0.2")}, resolver.findHost("web."))
+
+	resolver.SetConflictPolicy(ConflictOldest)
+	equals(t, []net.IP{net.ParseIP("10.0.0.3")}, resolver.findHost("web."))
+
+	resolver.RemoveHost("old")
+	equals(t, []net.IP{net.ParseIP("10.0.0.2")}, resolver.findHost("web."))
+	equals(t, 0, len(resolver.Conflicts()))
+}
+
+func TestConflictReject(t *testing.T) {
+	resolver := conflictingResolver(t, ConflictReject)
+
+	equals(t, []net.IP{net.ParseIP("10.0.0.1")}, resolver.findHost("web."))
+	equals(t, []net.IP{net.ParseIP("10.0.0.2")}, resolver.findHost("new.docker."))
+	equals(t, []string{"web rejected for new"}, resolver.Conflicts())
+
+	// unless the host is preferred
+	resolver.SetPreferred("preferred", true)
+	resolver.AddHost("preferred", net.ParseIP("10.0.0.3"), "WEB")
+	equals(t, []net.IP{net.ParseIP("10.0.0.3")}, resolver.findHost("web."))
+}
+
+func TestConflictRejectRemoved(t *testing.T) {
+	resolver := conflictingResolver(t, ConflictReject)
+	resolver.AddHost("newer", net.ParseIP("10.0.0.3"), "web")
+
+	// the name goes to the host that claimed it first once the owner is removed
+	resolver.RemoveHost("old")
+	equals(t, []net.IP{net.ParseIP("10.0.0.2")}, resolver.findHost("web."))
+	equals(t, []string{"web rejected for newer"}, resolver.Conflicts())
+
+	// or when the owner is added again without it
+	resolver.AddHost("new", net.ParseIP("10.0.0.2"), "new.docker")
+	equals(t, []net.IP{net.ParseIP("10.0.0.3")}, resolver.findHost("web."))
+	equals(t, 0, len(resolver.Conflicts()))
+}
+
+func TestConflictPreferred(t *testing.T) {
+	resolver, err := NewResolver()
+	ok(t, err)
+	resolver.SetConflictPolicy(ConflictNewest)
+
+	resolver.SetPreferred("old", true)
+	resolver.AddHost("old", net.ParseIP("10.0.0.1"), "web")
+	resolver.AddHost("new", net.ParseIP("10.0.0.2"), "web")
+	equals(t, []net.IP{net.ParseIP("10.0.0.1")}, resolver.findHost("web."))
+
+	// the preference is removed with the host
+	resolver.RemoveHost("old")
+	resolver.AddHost("old", net.ParseIP("10.0.0.1"), "web")
+	equals(t, []net.IP{net.ParseIP("10.0.0.1")}, resolver.findHost("web."))
+	resolver.SetConflictPolicy(ConflictOldest)
+	equals(t, []net.IP{net.ParseIP("10.0.0.2")}, resolver.findHost("web."))
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	policy, err := ParseConflictPolicy("newest")
+	ok(t, err)
+	equals(t, ConflictNewest, policy)
+
+	_, err = ParseConflictPolicy("first")
+	if err == nil {
+		t.Fatal("expected an error for an unknown policy")
+	}
+}
@@ -0,0 +1,74 @@
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+var privateNetworks = []string{
+	"127.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"169.254.0.0/16",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+// PrivateNetworks returns the loopback, private and link-local networks,
+// which are the default networks allowed to use recursion.
+func PrivateNetworks() []*net.IPNet {
+	nets, err := ParseNetworks(privateNetworks)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
+
+// ParseNetworks parses a list of networks in CIDR notation. Plain addresses
+// are accepted as a network containing only that address.
+func ParseNetworks(specs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		if !strings.Contains(spec, "/") {
+			ip := net.ParseIP(spec)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid network %q", spec)
+			}
+			nets = append(nets, hostNetwork(ip))
+			continue
+		}
+
+		_, ipnet, err := net.ParseCIDR(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", spec)
+		}
+		nets = append(nets, ipnet)
+	}
+
+	return nets, nil
+}
+
+func hostNetwork(ip net.IP) *net.IPNet {
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return &net.IPNet{IP: ipv4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+func networksContain(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipnet := range nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package resolver
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseNetworks(t *testing.T) {
+	nets, err := ParseNetworks([]string{"10.0.0.0/8", " 192.168.1.1 ", "", "::1"})
+	ok(t, err)
+	equals(t, 3, len(nets))
+
+	equals(t, "10.0.0.0/8", nets[0].String())
+	equals(t, "192.168.1.1/32", nets[1].String())
+	equals(t, "::1/128", nets[2].String())
+}
+
+func TestParseNetworksInvalid(t *testing.T) {
+	_, err := ParseNetworks([]string{"not-a-network"})
+	if err == nil {
+		t.Fatal("expected an error for an invalid address")
+	}
+
+	_, err = ParseNetworks([]string{"10.0.0.0/99"})
+	if err == nil {
+		t.Fatal("expected an error for an invalid mask")
+	}
+}
+
+func TestPrivateNetworks(t *testing.T) {
+	nets := PrivateNetworks()
+
+	for _, addr := range []string{"127.0.0.1", "10.1.2.3", "172.17.0.1", "192.168.0.10", "::1", "fd00::1"} {
+		if !networksContain(nets, net.ParseIP(addr)) {
+			t.Errorf("expected %s to be private", addr)
+		}
+	}
+
+	for _, addr := range []string{"8.8.8.8", "172.32.0.1", "2001:db8::1"} {
+		if networksContain(nets, net.ParseIP(addr)) {
+			t.Errorf("expected %s not to be private", addr)
+		}
+	}
+}
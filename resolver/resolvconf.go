@@ -1,87 +1,369 @@
 package resolver
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/resolvable/settings"
 )
 
 const RESOLVCONF_COMMENT = "# added by resolvable"
 
-var resolvConfPattern = regexp.MustCompile("(?m:^.*" + regexp.QuoteMeta(RESOLVCONF_COMMENT) + ")(?:$|\n)")
+// RESOLVCONF_DISABLED is prefixed to the lines disabled while resolvable is
+// running, to enable them again when it stops.
+const RESOLVCONF_DISABLED = "# disabled by resolvable: "
+
+// legacyDisabled was prefixed to every line of resolv.conf by earlier
+// releases, which is undone when recovering from one without a backup.
+const legacyDisabled = "# "
+
+// resolvConfPattern matches the lines added by resolvable: those ending in
+// RESOLVCONF_COMMENT, and lines that can't have a trailing comment, like
+// search, which follow a line with just the comment.
+var resolvConfPattern = regexp.MustCompile("(?m)(?:^" + regexp.QuoteMeta(RESOLVCONF_COMMENT) + "\n.*|^.*" + regexp.QuoteMeta(RESOLVCONF_COMMENT) + ")(?:\n|\\z)")
+
+// reassertInterval limits how often the entry is re-applied when another
+// program keeps rewriting resolv.conf.
+var reassertInterval = 5 * time.Second
 
 type ResolvConf struct {
-	path string
+	path      string
+	backup    string
+	recovered bool
+	// search adds the local domain to the search list
+	search bool
+	domain string
+
+	mutex        sync.Mutex
+	address      string
+	watcher      *FileWatcher
+	lastReassert time.Time
+	retry        *time.Timer
+	stopped      bool
 }
 
 func init() {
-	resolveConf := getopt("RESOLV_CONF", "/tmp/resolv.conf")
-	HostResolverConfigs.Register(&ResolvConf{resolveConf}, "resolvconf")
+	resolveConf := settings.String("RESOLV_CONF", "/tmp/resolv.conf", "resolv.conf file to insert resolvable into")
+	backup := settings.String("RESOLV_CONF_BACKUP", "", "backup of the original resolv.conf, default RESOLV_CONF.resolvable-backup")
+	if backup == "" {
+		backup = resolveConf + ".resolvable-backup"
+	}
+	search := settings.Bool("RESOLV_CONF_SEARCH", false, "add the local domain to the resolv.conf search list")
+	HostResolverConfigs.Register(&ResolvConf{path: resolveConf, backup: backup, search: search}, "resolvconf")
 }
 
 func (r *ResolvConf) StoreAddress(address string) error {
-	resolveConfEntry := fmt.Sprintf("nameserver %s %s\n", address, RESOLVCONF_COMMENT)
-	return updateResolvConf(resolveConfEntry, r.path)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.recovered {
+		if err := r.recover(); err != nil {
+			return err
+		}
+		r.recovered = true
+	}
+
+	if err := r.saveBackup(); err != nil {
+		return err
+	}
+
+	r.address = address
+	if err := updateResolvConf(r.address, r.domain, r.path); err != nil {
+		return err
+	}
+
+	if r.watcher == nil {
+		watcher, err := WatchFile(r.path, r.reassert)
+		if err != nil {
+			log.Println("not watching resolv.conf for changes:", err)
+		}
+		r.watcher = watcher
+	}
+	return nil
+}
+
+// UpdateInfo adds the local domain to the search list, when enabled with
+// RESOLV_CONF_SEARCH.
+func (r *ResolvConf) UpdateInfo(info ResolverInfo) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.search || info.LocalDomain == r.domain {
+		return nil
+	}
+	r.domain = info.LocalDomain
+	if r.address == "" || r.stopped {
+		return nil
+	}
+	return updateResolvConf(r.address, r.domain, r.path)
+}
+
+// Status checks resolvable is still the first nameserver in resolv.conf.
+func (r *ResolvConf) Status() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.address == "" {
+		return nil
+	}
+	current, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+	if firstNameserver(current) != strings.TrimSpace(nameserverEntry(r.address)) {
+		return fmt.Errorf("resolvable is not the first nameserver in %s", r.path)
+	}
+	return nil
 }
 
 func (r *ResolvConf) Clean() {
-	updateResolvConf("", r.path)
+	r.mutex.Lock()
+	r.stopped = true
+	if r.retry != nil {
+		r.retry.Stop()
+	}
+	watcher := r.watcher
+	r.mutex.Unlock()
+
+	// closing waits for a running reassert, which needs the mutex
+	if watcher != nil {
+		watcher.Close()
+	}
+
+	if err := updateResolvConf("", "", r.path); err != nil {
+		log.Println("error cleaning resolv.conf:", err)
+		return
+	}
+	os.Remove(r.backup)
 }
 
-func getopt(name, def string) string {
-	if env := os.Getenv(name); env != "" {
-		return env
+// reassert re-applies the entry when another program, like a DHCP client,
+// rewrote resolv.conf without it or with other nameservers before it.
+func (r *ResolvConf) reassert() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.stopped || r.address == "" {
+		return
+	}
+
+	current, err := ioutil.ReadFile(r.path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("error reading resolv.conf:", err)
+		return
+	}
+	if firstNameserver(current) == strings.TrimSpace(nameserverEntry(r.address)) {
+		return
+	}
+
+	if wait := r.lastReassert.Add(reassertInterval).Sub(time.Now()); wait > 0 {
+		if r.retry == nil {
+			r.retry = time.AfterFunc(wait, func() {
+				r.mutex.Lock()
+				r.retry = nil
+				r.mutex.Unlock()
+				r.reassert()
+			})
+		}
+		return
+	}
+	r.lastReassert = time.Now()
+
+	log.Println("resolv.conf was rewritten without resolvable as the first nameserver, re-applying:", r.path)
+	// the new contents are what should be restored after a crash
+	if err = r.saveBackup(); err != nil {
+		log.Println("error saving resolv.conf backup:", err)
+	}
+	if err = updateResolvConf(r.address, r.domain, r.path); err != nil {
+		log.Println("error updating resolv.conf:", err)
 	}
-	return def
 }
 
-func updateResolvConf(insert, path string) error {
-	log.Println("updating resolv.conf:", path)
+// firstNameserver returns the first nameserver line that is not commented out.
+func firstNameserver(conf []byte) string {
+	for _, line := range strings.Split(string(conf), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "nameserver") {
+			return line
+		}
+	}
+	return ""
+}
 
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
-	if err != nil {
+// recover restores resolv.conf if a previous run exited without cleaning up,
+// from the backup of the original contents if there is one.
+func (r *ResolvConf) recover() error {
+	current, err := ioutil.ReadFile(r.path)
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	defer f.Close()
 
-	orig, err := ioutil.ReadAll(f)
-	if err != nil {
+	if !resolvConfPattern.Match(current) {
+		// the file was already restored, so any backup is stale
+		os.Remove(r.backup)
+		return nil
+	}
+
+	orig, err := ioutil.ReadFile(r.backup)
+	if err == nil {
+		log.Println("restoring resolv.conf from backup left by previous run:", r.backup)
+		if err = WriteFileAtomic(r.path, orig, 0644); err != nil {
+			return err
+		}
+		return os.Remove(r.backup)
+	}
+	if !os.IsNotExist(err) {
 		return err
 	}
 
-	orig = resolvConfPattern.ReplaceAllLiteral(orig, []byte{})
+	log.Println("removing resolv.conf entries left by previous run:", r.path)
+	restored := rewriteResolvConf(current, "", "")
+	if legacyResolvConf(current) {
+		log.Println("enabling resolv.conf lines disabled by an earlier release:", r.path)
+		restored = enableLegacyLines(restored)
+	}
+	return WriteFileAtomic(r.path, restored, 0644)
+}
+
+// legacyResolvConf returns whether resolv.conf was modified by an earlier
+// release, which disabled all the other lines with legacyDisabled, including
+// the nameservers. The current release leaves those as they are.
+func legacyResolvConf(contents []byte) bool {
+	nameservers := 0
+	for _, line := range strings.Split(string(resolvConfPattern.ReplaceAllLiteral(contents, nil)), "\n") {
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, legacyDisabled) || strings.HasPrefix(line, RESOLVCONF_DISABLED) {
+			return false
+		}
+		if fields := strings.Fields(strings.TrimPrefix(line, legacyDisabled)); len(fields) > 0 && fields[0] == "nameserver" {
+			nameservers++
+		}
+	}
+	return nameservers > 0
+}
 
-	if _, err = f.Seek(0, os.SEEK_SET); err != nil {
+// enableLegacyLines removes legacyDisabled from each line, which also turns
+// the comments of the original file back into single comments.
+func enableLegacyLines(contents []byte) []byte {
+	lines := strings.SplitAfter(string(contents), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(line, legacyDisabled)
+	}
+	return []byte(strings.Join(lines, ""))
+}
+
+// saveBackup keeps a copy of resolv.conf before it is first modified.
+func (r *ResolvConf) saveBackup() error {
+	orig, err := ioutil.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
 		return err
 	}
 
-	if _, err = f.WriteString(insert); err != nil {
+	if resolvConfPattern.Match(orig) {
+		// already modified, the backup has the original contents
+		return nil
+	}
+	return WriteFileAtomic(r.backup, orig, 0644)
+}
+
+func updateResolvConf(address, domain, path string) error {
+	log.Println("updating resolv.conf:", path)
+
+	orig, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
 
-	lines := strings.SplitAfter(string(orig), "\n")
-	for _, line := range lines {
+	return WriteFileAtomic(path, rewriteResolvConf(orig, address, domain), 0644)
+}
+
+func nameserverEntry(address string) string {
+	return fmt.Sprintf("nameserver %s %s\n", address, RESOLVCONF_COMMENT)
+}
+
+// rewriteResolvConf removes previous resolvable entries from orig and enables
+// the lines they disabled. Unless address is empty, it then inserts a
+// nameserver entry for address and disables the other nameservers, leaving
+// search, options and the rest of the file as they are. If domain is set, it
+// is added to the search list.
+func rewriteResolvConf(orig []byte, address, domain string) []byte {
+	orig = resolvConfPattern.ReplaceAllLiteral(orig, []byte{})
+
+	var lines []string
+	var search []string
+	for _, line := range strings.SplitAfter(string(orig), "\n") {
 		// if file ends in a newline, skip empty string from splitting
 		if line == "" {
 			continue
 		}
-		if insert == "" {
-			line = strings.TrimLeft(line, "# ")
-		} else {
-			line = "# " + line
+		line = strings.TrimPrefix(line, RESOLVCONF_DISABLED)
+		// the last search or domain line sets the search list
+		if fields := strings.Fields(line); len(fields) > 0 && (fields[0] == "search" || fields[0] == "domain") {
+			search = fields[1:]
 		}
-		if _, err = f.WriteString(line); err != nil {
-			return err
+		lines = append(lines, line)
+	}
+
+	var buf bytes.Buffer
+	if address == "" {
+		for _, line := range lines {
+			buf.WriteString(line)
 		}
+		return buf.Bytes()
 	}
 
-	// contents may have been shortened, so truncate where we are
-	pos, err := f.Seek(0, os.SEEK_CUR)
-	if err != nil {
-		return err
+	addSearch := domain != ""
+	for _, s := range search {
+		if strings.EqualFold(s, domain) {
+			addSearch = false
+		}
+	}
+
+	// insert before the first nameserver, to keep any comments at the top
+	first := -1
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "nameserver" || (fields[0] == "search" && addSearch) {
+			lines[i] = RESOLVCONF_DISABLED + line
+		}
+		if fields[0] == "nameserver" && first < 0 {
+			first = i
+		}
+	}
+	if first < 0 {
+		first = 0
 	}
-	return f.Truncate(pos)
+
+	for _, line := range lines[:first] {
+		buf.WriteString(line)
+	}
+	buf.WriteString(nameserverEntry(address))
+	for _, line := range lines[first:] {
+		buf.WriteString(line)
+	}
+
+	if addSearch {
+		if buf.Len() > 0 && buf.Bytes()[buf.Len()-1] != '\n' {
+			buf.WriteString("\n")
+		}
+		// after any domain line, as the last one takes effect
+		fmt.Fprintf(&buf, "%s\nsearch %s\n", RESOLVCONF_COMMENT, strings.Join(append(search, domain), " "))
+	}
+
+	return buf.Bytes()
 }
@@ -4,8 +4,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strings"
 	"testing"
+	"time"
 )
 
 func tempdir(t *testing.T) string {
@@ -27,29 +27,23 @@ func assertFileContains(t *testing.T, path, expected string) {
 	}
 }
 
-func checkInsertLine(t *testing.T, path, line, orig string) {
-	err := updateResolvConf(line+"\n", path)
+func checkInsert(t *testing.T, path, domain, expected string) {
+	err := updateResolvConf("1.2.3.4", domain, path)
 	if err != nil {
 		t.Fatal("could not insert line:", err)
 	}
 
-	lines := strings.SplitAfter(orig, "\n")
-	for i, line := range lines {
-		if line != "" {
-			lines[i] = "# " + line
-		}
-	}
-	commented := strings.Join(lines, "")
-
-	assertFileContains(t, path, line+"\n"+commented)
+	assertFileContains(t, path, expected)
 }
 
+const testEntry = "nameserver 1.2.3.4 " + RESOLVCONF_COMMENT + "\n"
+
 func TestInsertLineNewFile(t *testing.T) {
 	dir := tempdir(t)
 	defer os.RemoveAll(dir)
 
 	path := filepath.Join(dir, "test.txt")
-	checkInsertLine(t, path, "hello world", "")
+	checkInsert(t, path, "", testEntry)
 }
 
 func TestInsertLineEmptyFile(t *testing.T) {
@@ -61,7 +55,7 @@ func TestInsertLineEmptyFile(t *testing.T) {
 	if err != nil {
 		t.Fatal("could not create file:", err)
 	}
-	checkInsertLine(t, path, "hello world", "")
+	checkInsert(t, path, "", testEntry)
 }
 
 func TestInsertLineExistingFile(t *testing.T) {
@@ -69,12 +63,18 @@ func TestInsertLineExistingFile(t *testing.T) {
 	defer os.RemoveAll(dir)
 
 	path := filepath.Join(dir, "test.txt")
-	orig := "existing text\nanother line\n"
+	orig := "# generated by dhcp\nsearch example.com\nnameserver 8.8.8.8\nnameserver 8.8.4.4\noptions ndots:2 timeout:1\n"
 	err := ioutil.WriteFile(path, []byte(orig), 0666)
 	if err != nil {
 		t.Fatal("could not create file:", err)
 	}
-	checkInsertLine(t, path, "hello world", orig)
+
+	checkInsert(t, path, "", "# generated by dhcp\nsearch example.com\n"+testEntry+
+		RESOLVCONF_DISABLED+"nameserver 8.8.8.8\n"+
+		RESOLVCONF_DISABLED+"nameserver 8.8.4.4\n"+
+		"options ndots:2 timeout:1\n")
+
+	checkRemoveLine(t, path, orig)
 }
 
 func TestInsertLineExistingFileWithComments(t *testing.T) {
@@ -83,18 +83,56 @@ func TestInsertLineExistingFileWithComments(t *testing.T) {
 
 	path := filepath.Join(dir, "test.txt")
 
-	expected := "existing text\nanother line\n"
+	expected := "options rotate\n"
 	orig := expected + "comment line " + RESOLVCONF_COMMENT
 
 	err := ioutil.WriteFile(path, []byte(orig), 0666)
 	if err != nil {
 		t.Fatal("could not create file:", err)
 	}
-	checkInsertLine(t, path, "hello world", expected)
+	checkInsert(t, path, "", testEntry+expected)
+}
+
+func TestInsertSearchDomain(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.txt")
+	orig := "search example.com\nnameserver 8.8.8.8\noptions ndots:2"
+	err := ioutil.WriteFile(path, []byte(orig), 0666)
+	if err != nil {
+		t.Fatal("could not create file:", err)
+	}
+
+	checkInsert(t, path, "docker", RESOLVCONF_DISABLED+"search example.com\n"+testEntry+
+		RESOLVCONF_DISABLED+"nameserver 8.8.8.8\n"+
+		"options ndots:2\n"+
+		RESOLVCONF_COMMENT+"\nsearch example.com docker\n")
+
+	// inserting again doesn't add the domain twice
+	checkInsert(t, path, "docker", RESOLVCONF_DISABLED+"search example.com\n"+testEntry+
+		RESOLVCONF_DISABLED+"nameserver 8.8.8.8\n"+
+		"options ndots:2\n"+
+		RESOLVCONF_COMMENT+"\nsearch example.com docker\n")
+
+	checkRemoveLine(t, path, orig+"\n")
+}
+
+func TestInsertSearchDomainAlreadyListed(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.txt")
+	err := ioutil.WriteFile(path, []byte("domain docker\n"), 0666)
+	if err != nil {
+		t.Fatal("could not create file:", err)
+	}
+
+	checkInsert(t, path, "docker", testEntry+"domain docker\n")
 }
 
 func checkRemoveLine(t *testing.T, path, expected string) {
-	err := updateResolvConf("", path)
+	err := updateResolvConf("", "", path)
 	if err != nil {
 		t.Fatal("could not remove line:", err)
 	}
@@ -106,7 +144,7 @@ func TestRemoveLineMissingFile(t *testing.T) {
 	dir := tempdir(t)
 	defer os.RemoveAll(dir)
 
-	err := updateResolvConf("", filepath.Join(dir, "test.txt"))
+	err := updateResolvConf("", "", filepath.Join(dir, "test.txt"))
 	if err != nil {
 		t.Fatal("could not remove line:", err)
 	}
@@ -182,3 +220,217 @@ func TestRemoveLineMulti(t *testing.T) {
 
 	checkRemoveLine(t, path, expected)
 }
+
+func TestStoreAddressBackup(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "resolv.conf")
+	backup := filepath.Join(dir, "resolv.conf.backup")
+	orig := "nameserver 8.8.8.8\n"
+
+	err := ioutil.WriteFile(path, []byte(orig), 0644)
+	if err != nil {
+		t.Fatal("could not create file:", err)
+	}
+
+	conf := &ResolvConf{path: path, backup: backup}
+	if err = conf.StoreAddress("1.2.3.4"); err != nil {
+		t.Fatal("could not store address:", err)
+	}
+
+	assertFileContains(t, path, testEntry+RESOLVCONF_DISABLED+orig)
+	assertFileContains(t, backup, orig)
+
+	// storing again keeps the original backup
+	if err = conf.StoreAddress("5.6.7.8"); err != nil {
+		t.Fatal("could not store address:", err)
+	}
+	assertFileContains(t, backup, orig)
+
+	conf.Clean()
+
+	assertFileContains(t, path, orig)
+	if _, err = os.Stat(backup); !os.IsNotExist(err) {
+		t.Error("expected backup to be removed, got:", err)
+	}
+}
+
+func TestRecoverFromBackup(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "resolv.conf")
+	backup := filepath.Join(dir, "resolv.conf.backup")
+	orig := "# generated by dhcp\nnameserver 8.8.8.8\n"
+
+	// left behind by a previous run that was killed
+	leftover := "# generated by dhcp\n" + testEntry + RESOLVCONF_DISABLED + "nameserver 8.8.8.8\n"
+	if err := ioutil.WriteFile(path, []byte(leftover), 0644); err != nil {
+		t.Fatal("could not create file:", err)
+	}
+	if err := ioutil.WriteFile(backup, []byte(orig), 0644); err != nil {
+		t.Fatal("could not create file:", err)
+	}
+
+	conf := &ResolvConf{path: path, backup: backup}
+	if err := conf.recover(); err != nil {
+		t.Fatal("could not recover:", err)
+	}
+
+	assertFileContains(t, path, orig)
+	if _, err := os.Stat(backup); !os.IsNotExist(err) {
+		t.Error("expected backup to be removed, got:", err)
+	}
+}
+
+func TestRecoverWithoutBackup(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "resolv.conf")
+
+	leftover := testEntry + RESOLVCONF_DISABLED + "nameserver 8.8.8.8\n"
+	if err := ioutil.WriteFile(path, []byte(leftover), 0644); err != nil {
+		t.Fatal("could not create file:", err)
+	}
+
+	conf := &ResolvConf{path: path, backup: filepath.Join(dir, "missing")}
+	if err := conf.recover(); err != nil {
+		t.Fatal("could not recover:", err)
+	}
+
+	assertFileContains(t, path, "nameserver 8.8.8.8\n")
+}
+
+func TestRecoverLegacyWithoutBackup(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "resolv.conf")
+
+	// earlier releases disabled every other line with "# "
+	leftover := testEntry + "# # Generated by NetworkManager\n# nameserver 8.8.8.8\n# search example.com\n"
+	if err := ioutil.WriteFile(path, []byte(leftover), 0644); err != nil {
+		t.Fatal("could not create file:", err)
+	}
+
+	conf := &ResolvConf{path: path, backup: filepath.Join(dir, "missing")}
+	if err := conf.recover(); err != nil {
+		t.Fatal("could not recover:", err)
+	}
+
+	assertFileContains(t, path, "# Generated by NetworkManager\nnameserver 8.8.8.8\nsearch example.com\n")
+}
+
+func TestRecoverKeepsComments(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "resolv.conf")
+
+	// a file without other nameservers, left by the current release
+	leftover := "# Generated by NetworkManager\n" + testEntry
+	if err := ioutil.WriteFile(path, []byte(leftover), 0644); err != nil {
+		t.Fatal("could not create file:", err)
+	}
+
+	conf := &ResolvConf{path: path, backup: filepath.Join(dir, "missing")}
+	if err := conf.recover(); err != nil {
+		t.Fatal("could not recover:", err)
+	}
+
+	assertFileContains(t, path, "# Generated by NetworkManager\n")
+}
+
+func TestRecoverRemovesStaleBackup(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "resolv.conf")
+	backup := filepath.Join(dir, "resolv.conf.backup")
+	current := "nameserver 8.8.4.4\n"
+
+	if err := ioutil.WriteFile(path, []byte(current), 0644); err != nil {
+		t.Fatal("could not create file:", err)
+	}
+	if err := ioutil.WriteFile(backup, []byte("nameserver 8.8.8.8\n"), 0644); err != nil {
+		t.Fatal("could not create file:", err)
+	}
+
+	conf := &ResolvConf{path: path, backup: backup}
+	if err := conf.recover(); err != nil {
+		t.Fatal("could not recover:", err)
+	}
+
+	assertFileContains(t, path, current)
+	if _, err := os.Stat(backup); !os.IsNotExist(err) {
+		t.Error("expected backup to be removed, got:", err)
+	}
+}
+
+func TestReassertAfterRewrite(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "resolv.conf")
+	if err := ioutil.WriteFile(path, []byte("nameserver 8.8.8.8\n"), 0644); err != nil {
+		t.Fatal("could not create file:", err)
+	}
+
+	conf := &ResolvConf{path: path, backup: filepath.Join(dir, "resolv.conf.backup")}
+	if err := conf.StoreAddress("1.2.3.4"); err != nil {
+		t.Fatal("could not store address:", err)
+	}
+
+	// replaced by a DHCP client
+	rewritten := "nameserver 8.8.4.4\n"
+	tmp := filepath.Join(dir, "resolv.conf.dhcp")
+	if err := ioutil.WriteFile(tmp, []byte(rewritten), 0644); err != nil {
+		t.Fatal("could not create file:", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal("could not replace file:", err)
+	}
+
+	expected := testEntry + RESOLVCONF_DISABLED + rewritten
+	for i := 0; i < 50; i++ {
+		if got, _ := ioutil.ReadFile(path); string(got) == expected {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	assertFileContains(t, path, expected)
+	assertFileContains(t, conf.backup, rewritten)
+
+	conf.Clean()
+	assertFileContains(t, path, rewritten)
+}
+
+func TestStatus(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "resolv.conf")
+	conf := &ResolvConf{path: path, backup: filepath.Join(dir, "resolv.conf.backup")}
+	if err := conf.Status(); err != nil {
+		t.Error("expected no error before storing the address, got:", err)
+	}
+
+	if err := conf.StoreAddress("1.2.3.4"); err != nil {
+		t.Fatal("could not store address:", err)
+	}
+	defer conf.Clean()
+	if err := conf.Status(); err != nil {
+		t.Error("expected no error, got:", err)
+	}
+
+	// stop watching, so the change isn't reverted
+	conf.watcher.Close()
+	if err := ioutil.WriteFile(path, []byte("nameserver 8.8.8.8\n"+testEntry), 0644); err != nil {
+		t.Fatal("could not write file:", err)
+	}
+	if err := conf.Status(); err == nil {
+		t.Error("expected an error when resolvable is not the first nameserver")
+	}
+}
@@ -1,11 +1,15 @@
 package resolver
 
 import (
-	"fmt"
+	"bytes"
+	"errors"
 	"log"
 	"net"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/miekg/dns"
 )
@@ -13,6 +17,10 @@ import (
 type Resolver interface {
 	AddHost(id string, addr net.IP, name string, aliases ...string) error
 	RemoveHost(id string) error
+	// SetPreferred marks the host with id as the preferred owner of its names
+	// when they conflict with those of other hosts, and can be set before the
+	// host is added.
+	SetPreferred(id string, preferred bool) error
 
 	AddUpstream(id string, addr net.IP, port int, domain ...string) error
 	RemoveUpstream(id string) error
@@ -24,55 +32,256 @@ type Resolver interface {
 type hostsEntry struct {
 	Address net.IP
 	Names   []string
+	// names not registered due to ConflictReject
+	Rejected []string
+
+	id string
+	// conflicting hosts are ordered by when they were first added
+	seq uint64
 }
 
 type serversEntry struct {
 	Address net.IP
 	Port    int
 	Domains []string
+	// servers for the same domains are tried in the order they were added
+	seq uint64
+	// the options of the resolv.conf the server is from, if any, instead
+	// of those set with SetForwardOptions
+	timeout  time.Duration
+	attempts int
 }
 
 type dnsResolver struct {
 	hostMutex     sync.RWMutex
 	upstreamMutex sync.RWMutex
 
-	Port     int
-	hosts    map[string]*hostsEntry
-	upstream map[string]*serversEntry
-	server   *dns.Server
-	stopped  chan struct{}
+	Port      int
+	Addresses []net.IP
+
+	// pre-opened sockets to serve on instead of binding Addresses
+	PacketConns []net.PacketConn
+	Listeners   []net.Listener
+
+	hosts       *hostStore
+	hostSeq     uint64
+	preferred   map[string]bool
+	conflicts   ConflictPolicy
+	rotation    uint32
+	upstream    map[string]*serversEntry
+	upstreamSeq uint64
+	stopped     chan struct{}
+	// called after hosts are added or removed
+	onHostsChanged func()
+
+	// how long to wait for each upstream server, and how many times to try
+	// each of them, guarded by upstreamMutex
+	forwardTimeout  time.Duration
+	forwardAttempts int
+
+	// TTL of the records for containers, in seconds, and whether each query
+	// is logged
+	optionsMutex sync.RWMutex
+	ttl          uint32
+	logQueries   bool
+
+	serverMutex sync.RWMutex
+	servers     []*dns.Server
+
+	// clients in these networks may have queries forwarded upstream
+	recursionMutex sync.RWMutex
+	recursionNets  []*net.IPNet
+
+	// clients must be in an allowed network, and not in a denied one
+	accessMutex sync.RWMutex
+	allowNets   []*net.IPNet
+	denyNets    []*net.IPNet
+	refusedLog  *logLimiter
+
+	// zones accepting dynamic updates, and the leases of the hosts added by
+	// them; updates are applied one at a time
+	updateMutex sync.Mutex
+	updateZones []string
+	updateLease time.Duration
+	updated     map[string]*updateLease
+	tsig        *tsigKeys
 }
 
 func NewResolver() (*dnsResolver, error) {
+	allowNets, err := DefaultAllowNetworks()
+	if err != nil {
+		return nil, err
+	}
+
 	return &dnsResolver{
-		Port:     53,
-		hosts:    make(map[string]*hostsEntry),
-		upstream: make(map[string]*serversEntry),
-		stopped:  make(chan struct{}),
+		Port:            53,
+		hosts:           newHostStore(),
+		preferred:       make(map[string]bool),
+		conflicts:       ConflictMerge,
+		upstream:        make(map[string]*serversEntry),
+		stopped:         make(chan struct{}),
+		forwardTimeout:  defaultForwardTimeout,
+		forwardAttempts: 1,
+		recursionNets:   PrivateNetworks(),
+		allowNets:       allowNets,
+		refusedLog:      newLogLimiter(refusedLogInterval),
+		updated:         make(map[string]*updateLease),
+		tsig:            &tsigKeys{},
 	}, nil
 }
 
+// SetRecursionNetworks limits forwarding queries to upstream servers to
+// clients within the given networks. Clients outside these networks are only
+// answered from local data. An empty list disables recursion entirely.
+func (r *dnsResolver) SetRecursionNetworks(nets []*net.IPNet) {
+	r.recursionMutex.Lock()
+	defer r.recursionMutex.Unlock()
+
+	r.recursionNets = nets
+}
+
+// SetTTL sets the TTL of the records for containers, in seconds.
+func (r *dnsResolver) SetTTL(ttl uint32) {
+	r.optionsMutex.Lock()
+	defer r.optionsMutex.Unlock()
+
+	r.ttl = ttl
+}
+
+// SetLogQueries enables logging each query and its response code.
+func (r *dnsResolver) SetLogQueries(enabled bool) {
+	r.optionsMutex.Lock()
+	defer r.optionsMutex.Unlock()
+
+	r.logQueries = enabled
+}
+
+func (r *dnsResolver) options() (ttl uint32, logQueries bool) {
+	r.optionsMutex.RLock()
+	defer r.optionsMutex.RUnlock()
+
+	return r.ttl, r.logQueries
+}
+
+func (r *dnsResolver) recursionAllowed(client net.IP) bool {
+	r.recursionMutex.RLock()
+	defer r.recursionMutex.RUnlock()
+
+	return client != nil && networksContain(r.recursionNets, client)
+}
+
 func (r *dnsResolver) AddHost(id string, addr net.IP, name string, aliases ...string) error {
 	r.hostMutex.Lock()
+	defer r.hostsChanged()
 	defer r.hostMutex.Unlock()
 
-	r.hosts[id] = &hostsEntry{Address: addr, Names: append([]string{name}, aliases...)}
+	entry := &hostsEntry{Address: addr, Names: append([]string{name}, aliases...), id: id}
+	// a host added again, e.g. with a new address, keeps its age
+	existing, ok := r.hosts.get(id)
+	if ok {
+		entry.seq = existing.seq
+	} else {
+		r.hostSeq++
+		entry.seq = r.hostSeq
+	}
+	r.checkConflicts(entry)
+	r.hosts.add(entry)
+	if ok {
+		r.promoteRejected(existing.Names)
+	}
 	return nil
 }
 
 func (r *dnsResolver) RemoveHost(id string) error {
 	r.hostMutex.Lock()
+	defer r.hostsChanged()
 	defer r.hostMutex.Unlock()
 
-	delete(r.hosts, id)
+	existing, ok := r.hosts.get(id)
+	r.hosts.remove(id)
+	delete(r.preferred, id)
+	if ok {
+		r.promoteRejected(existing.Names)
+	}
 	return nil
 }
 
+// OnHostsChanged sets a function called after each host is added or
+// removed.
+func (r *dnsResolver) OnHostsChanged(changed func()) {
+	r.hostMutex.Lock()
+	defer r.hostMutex.Unlock()
+
+	r.onHostsChanged = changed
+}
+
+func (r *dnsResolver) hostsChanged() {
+	r.hostMutex.RLock()
+	changed := r.onHostsChanged
+	r.hostMutex.RUnlock()
+
+	if changed != nil {
+		changed()
+	}
+}
+
+// Records returns the hosts being served, sorted by address.
+func (r *dnsResolver) Records() []HostRecord {
+	r.hostMutex.RLock()
+	defer r.hostMutex.RUnlock()
+
+	entries := make([]*hostsEntry, 0, r.hosts.len())
+	for _, entry := range r.hosts.hosts {
+		entries = append(entries, entry)
+	}
+	sortHosts(entries)
+
+	records := []HostRecord{}
+	for _, entry := range entries {
+		if entry.Address != nil && len(entry.Names) > 0 {
+			records = append(records, HostRecord{ID: entry.id, Address: entry.Address.String(), Names: append([]string{}, entry.Names...)})
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(records[i].Address), net.ParseIP(records[j].Address)) < 0
+	})
+	return records
+}
+
+// Upstreams returns the upstream servers, in the order they were added. The
+// local domains, which are not forwarded, are left out.
+func (r *dnsResolver) Upstreams() []UpstreamRecord {
+	r.upstreamMutex.RLock()
+	defer r.upstreamMutex.RUnlock()
+
+	var entries []*serversEntry
+	ids := make(map[*serversEntry]string)
+	for id, upstream := range r.upstream {
+		if upstream.Address != nil {
+			entries = append(entries, upstream)
+			ids[upstream] = id
+		}
+	}
+	sortServers(entries)
+
+	upstreams := []UpstreamRecord{}
+	for _, upstream := range entries {
+		upstreams = append(upstreams, UpstreamRecord{
+			ID:      ids[upstream],
+			Address: upstream.Address.String(),
+			Port:    upstream.Port,
+			Domains: append([]string{}, upstream.Domains...),
+		})
+	}
+	return upstreams
+}
+
 func (r *dnsResolver) AddUpstream(id string, addr net.IP, port int, domains ...string) error {
 	r.upstreamMutex.Lock()
 	defer r.upstreamMutex.Unlock()
 
-	r.upstream[id] = &serversEntry{Address: addr, Port: port, Domains: domains}
+	r.upstreamSeq++
+	r.upstream[id] = &serversEntry{Address: addr, Port: port, Domains: domains, seq: r.upstreamSeq}
 	return nil
 }
 
@@ -85,38 +294,120 @@ func (r *dnsResolver) RemoveUpstream(id string) error {
 }
 
 func (r *dnsResolver) Listen() error {
-	addr := fmt.Sprintf(":%d", r.Port)
+	if len(r.PacketConns) > 0 || len(r.Listeners) > 0 {
+		return r.listenActivated()
+	}
 
-	listenAddr, err := net.ResolveUDPAddr("udp4", addr)
+	conns, err := r.listenPacket()
 	if err != nil {
 		return err
 	}
 
-	conn, err := net.ListenUDP("udp4", listenAddr)
-	if err != nil {
-		return err
+	r.Port = conns[0].LocalAddr().(*net.UDPAddr).Port
+
+	var servers []*dns.Server
+	for _, conn := range conns {
+		servers = append(servers, &dns.Server{Handler: r, PacketConn: conn, TsigProvider: r.tsig, MsgAcceptFunc: acceptMsg})
 	}
 
-	r.Port = conn.LocalAddr().(*net.UDPAddr).Port
+	return r.serve(servers)
+}
 
-	startupError := make(chan error)
-	r.server = &dns.Server{Handler: r, PacketConn: conn, NotifyStartedFunc: func() {
-		startupError <- nil
-	}}
+// listenActivated serves on the sockets in PacketConns and Listeners, such as
+// those passed in by systemd socket activation.
+func (r *dnsResolver) listenActivated() error {
+	var servers []*dns.Server
+	for _, conn := range r.PacketConns {
+		servers = append(servers, &dns.Server{Handler: r, PacketConn: conn, TsigProvider: r.tsig, MsgAcceptFunc: acceptMsg})
+	}
+	for _, listener := range r.Listeners {
+		servers = append(servers, &dns.Server{Handler: r, Listener: listener, TsigProvider: r.tsig, MsgAcceptFunc: acceptMsg})
+	}
 
-	go func() {
-		select {
-		case startupError <- r.run():
-		default:
+	if len(r.PacketConns) > 0 {
+		if addr, ok := r.PacketConns[0].LocalAddr().(*net.UDPAddr); ok {
+			r.Port = addr.Port
 		}
-	}()
+	} else if addr, ok := r.Listeners[0].Addr().(*net.TCPAddr); ok {
+		r.Port = addr.Port
+	}
 
-	return <-startupError
+	return r.serve(servers)
 }
 
-func (r *dnsResolver) run() error {
-	defer close(r.stopped)
-	return r.server.ActivateAndServe()
+// listenPacket opens a UDP socket on each address in Addresses, or on all IPv4
+// addresses if none are set. When Port is 0 the port chosen for the first
+// socket is used for the rest.
+func (r *dnsResolver) listenPacket() ([]net.PacketConn, error) {
+	if len(r.Addresses) == 0 {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: r.Port})
+		if err != nil {
+			return nil, err
+		}
+		return []net.PacketConn{conn}, nil
+	}
+
+	port := r.Port
+	conns := make([]net.PacketConn, 0, len(r.Addresses))
+
+	for _, addr := range r.Addresses {
+		network := "udp6"
+		if addr.To4() != nil {
+			network = "udp4"
+		}
+
+		conn, err := net.ListenUDP(network, &net.UDPAddr{IP: addr, Port: port})
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+
+		port = conn.LocalAddr().(*net.UDPAddr).Port
+		conns = append(conns, conn)
+	}
+
+	return conns, nil
+}
+
+func (r *dnsResolver) serve(servers []*dns.Server) error {
+	r.serverMutex.Lock()
+	r.servers = servers
+	r.serverMutex.Unlock()
+
+	startupError := make(chan error, len(servers))
+
+	var running sync.WaitGroup
+	running.Add(len(servers))
+
+	for _, server := range servers {
+		server.NotifyStartedFunc = func() {
+			startupError <- nil
+		}
+
+		go func(server *dns.Server) {
+			defer running.Done()
+			err := server.ActivateAndServe()
+			select {
+			case startupError <- err:
+			default:
+			}
+		}(server)
+	}
+
+	go func() {
+		running.Wait()
+		close(r.stopped)
+	}()
+
+	for range servers {
+		if err := <-startupError; err != nil {
+			r.Close()
+			return err
+		}
+	}
+	return nil
 }
 
 func (r *dnsResolver) Wait() error {
@@ -124,14 +415,109 @@ func (r *dnsResolver) Wait() error {
 	return nil
 }
 
+// Domains returns the domains forwarded to upstream servers, sorted. Local
+// domains, which are not forwarded, are not included.
+func (r *dnsResolver) Domains() []string {
+	r.upstreamMutex.RLock()
+	defer r.upstreamMutex.RUnlock()
+
+	seen := make(map[string]bool)
+	domains := []string{}
+
+	for _, upstream := range r.upstream {
+		if upstream.Address == nil {
+			continue
+		}
+		for _, domain := range upstream.Domains {
+			domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+			if !seen[domain] {
+				seen[domain] = true
+				domains = append(domains, domain)
+			}
+		}
+	}
+
+	sort.Strings(domains)
+	return domains
+}
+
+// Stats returns the number of hosts and upstream servers registered.
+func (r *dnsResolver) Stats() (hosts, upstreams int) {
+	r.hostMutex.RLock()
+	hosts = r.hosts.len()
+	r.hostMutex.RUnlock()
+
+	r.upstreamMutex.RLock()
+	upstreams = len(r.upstream)
+	r.upstreamMutex.RUnlock()
+
+	return
+}
+
+// Check sends a query to the resolver over its first socket, and returns an
+// error if it isn't answered. Recursion is not requested, so the check does
+// not depend on any upstream servers.
+func (r *dnsResolver) Check() error {
+	r.serverMutex.RLock()
+	servers := r.servers
+	r.serverMutex.RUnlock()
+
+	if len(servers) == 0 {
+		return errors.New("resolver is not listening")
+	}
+
+	var network string
+	var addr net.Addr
+	if conn := servers[0].PacketConn; conn != nil {
+		network, addr = "udp", conn.LocalAddr()
+	} else {
+		network, addr = "tcp", servers[0].Listener.Addr()
+	}
+
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "127.0.0.1"
+		if ip != nil && ip.To4() == nil {
+			host = "::1"
+		}
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("resolvable.check.", dns.TypeA)
+	m.RecursionDesired = false
+
+	c := &dns.Client{Net: network, Timeout: 5 * time.Second}
+	_, _, err = c.Exchange(m, net.JoinHostPort(host, port))
+	return err
+}
+
 func (r *dnsResolver) Close() {
-	if r.server != nil {
-		r.server.Shutdown()
+	r.serverMutex.RLock()
+	defer r.serverMutex.RUnlock()
+
+	for _, server := range r.servers {
+		server.Shutdown()
 	}
 }
 
 func (r *dnsResolver) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
-	response, err := r.responseForQuery(query)
+	client := remoteIP(w.RemoteAddr())
+
+	var response *dns.Msg
+	var err error
+
+	switch {
+	case !r.clientAllowed(client):
+		r.logRefused(client, queryName(query))
+		response = dnsRefused(query)
+	case query.Opcode == dns.OpcodeUpdate:
+		response = r.answerUpdate(query, w.TsigStatus(), client)
+	default:
+		response, err = r.responseForQuery(query, client)
+	}
 	if err != nil {
 		log.Printf("response error: %T %s", err, err)
 		return
@@ -139,6 +525,9 @@ func (r *dnsResolver) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
 	if response == nil {
 		return
 	}
+	if _, logQueries := r.options(); logQueries {
+		log.Printf("query from %s: %s %s: %s", client, queryType(query), queryName(query), dns.RcodeToString[response.Rcode])
+	}
 
 	err = w.WriteMsg(response)
 	if err != nil {
@@ -146,73 +535,143 @@ func (r *dnsResolver) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
 	}
 }
 
-func (r *dnsResolver) responseForQuery(query *dns.Msg) (*dns.Msg, error) {
+func (r *dnsResolver) responseForQuery(query *dns.Msg, client net.IP) (*dns.Msg, error) {
+	recursion := r.recursionAllowed(client)
+
+	resp, err := r.answerQuery(query, recursion)
+	if resp != nil {
+		resp.RecursionAvailable = recursion
+	}
+	return resp, err
+}
+
+func (r *dnsResolver) answerQuery(query *dns.Msg, recursion bool) (*dns.Msg, error) {
 	// TODO multiple queries?
 	name := query.Question[0].Name
+	ttl, _ := r.options()
 
 	if query.Question[0].Qtype == dns.TypeA {
 		if addrs := r.findHost(name); len(addrs) > 0 {
-			return dnsAddressRecord(query, name, addrs), nil
+			return dnsAddressRecord(query, name, addrs, ttl), nil
 		}
 	} else if query.Question[0].Qtype == dns.TypePTR {
 		if hosts := r.findReverse(name); len(hosts) > 0 {
-			return dnsPtrRecord(query, name, hosts), nil
+			return dnsPtrRecord(query, name, hosts, ttl), nil
 		}
 	}
 
-	// What if RecursionDesired = false?
-	if resp, err := r.findUpstream(name, query); resp != nil || err != nil {
-		return resp, err
+	upstreams := r.upstreamsForHost(name)
+	if len(upstreams) == 0 || upstreams[0].Address == nil {
+		// nothing to forward to, or the name is within a local domain
+		return dnsNotFound(query), nil
 	}
 
-	return dnsNotFound(query), nil
+	if !query.RecursionDesired || !recursion {
+		return dnsRefused(query), nil
+	}
+
+	return r.forward(upstreams, query)
 }
 
-func (r *dnsResolver) upstreamForHost(name string) (matchedUpstream *serversEntry) {
+// upstreamsForHost returns the servers for the longest domain matching name,
+// or the servers without domains if none match, in the order they were added.
+func (r *dnsResolver) upstreamsForHost(name string) []*serversEntry {
 	r.upstreamMutex.RLock()
 	defer r.upstreamMutex.RUnlock()
 
+	// names are case-insensitive
+	name = strings.ToLower(name)
+
+	var matched []*serversEntry
 	matchedDomain := ""
 
 	for _, upstream := range r.upstream {
 		if len(upstream.Domains) == 0 && matchedDomain == "" {
-			matchedUpstream = upstream
+			matched = append(matched, upstream)
 		}
 
 		for _, domain := range upstream.Domains {
-			domain = dns.Fqdn(domain)
-			if len(domain) > len(matchedDomain) && (domain == name || strings.HasSuffix(name, "."+domain)) {
+			domain = strings.ToLower(dns.Fqdn(domain))
+			if !(domain == name || strings.HasSuffix(name, "."+domain)) {
+				continue
+			}
+			if len(domain) > len(matchedDomain) {
 				matchedDomain = domain
-				matchedUpstream = upstream
+				matched = []*serversEntry{upstream}
+			} else if domain == matchedDomain {
+				matched = append(matched, upstream)
 			}
 		}
 	}
 
-	return
+	sortServers(matched)
+	return matched
+}
+
+// sortServers sorts servers in the order they were added.
+func sortServers(servers []*serversEntry) {
+	sort.Slice(servers, func(i, j int) bool { return servers[i].seq < servers[j].seq })
 }
 
-func (r *dnsResolver) findUpstream(name string, msg *dns.Msg) (*dns.Msg, error) {
-	upstream := r.upstreamForHost(name)
-	if upstream == nil || upstream.Address == nil {
-		return nil, nil
+// SetForwardOptions sets how long to wait for an answer from each upstream
+// server, and how many times to try the servers, like the timeout and
+// attempts options in resolv.conf. The servers from a resolv.conf use its
+// options instead.
+func (r *dnsResolver) SetForwardOptions(timeout time.Duration, attempts int) {
+	r.upstreamMutex.Lock()
+	defer r.upstreamMutex.Unlock()
+
+	if attempts < 1 {
+		attempts = 1
 	}
+	r.forwardTimeout = timeout
+	r.forwardAttempts = attempts
+}
 
-	c := &dns.Client{Net: "udp"}
-	addr := fmt.Sprintf("%s:%d", upstream.Address.String(), upstream.Port)
-	resp, _, err := c.Exchange(msg, addr)
-	return resp, err
+// forward tries each upstream server in turn, until one answers, as many
+// times as each server's attempts.
+func (r *dnsResolver) forward(upstreams []*serversEntry, msg *dns.Msg) (*dns.Msg, error) {
+	r.upstreamMutex.RLock()
+	defaultTimeout, defaultAttempts := r.forwardTimeout, r.forwardAttempts
+	r.upstreamMutex.RUnlock()
+
+	options := func(upstream *serversEntry) (time.Duration, int) {
+		if upstream.attempts > 0 {
+			return upstream.timeout, upstream.attempts
+		}
+		return defaultTimeout, defaultAttempts
+	}
+	attempts := 0
+	for _, upstream := range upstreams {
+		if _, n := options(upstream); n > attempts {
+			attempts = n
+		}
+	}
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		for _, upstream := range upstreams {
+			timeout, n := options(upstream)
+			if i >= n {
+				continue
+			}
+			c := &dns.Client{Net: "udp", Timeout: timeout}
+			addr := net.JoinHostPort(upstream.Address.String(), strconv.Itoa(upstream.Port))
+			var resp *dns.Msg
+			if resp, _, err = c.Exchange(msg, addr); err == nil {
+				return resp, nil
+			}
+		}
+	}
+	return nil, err
 }
 
 func (r *dnsResolver) findHost(name string) (addrs []net.IP) {
 	r.hostMutex.RLock()
 	defer r.hostMutex.RUnlock()
 
-	for _, hosts := range r.hosts {
-		for _, hostName := range hosts.Names {
-			if dns.Fqdn(hostName) == name {
-				addrs = append(addrs, hosts.Address)
-			}
-		}
+	for _, entry := range r.resolveConflict(r.hosts.byName(name)) {
+		addrs = append(addrs, entry.Address)
 	}
 	return
 }
@@ -221,22 +680,22 @@ func (r *dnsResolver) findReverse(address string) (hosts []string) {
 	r.hostMutex.RLock()
 	defer r.hostMutex.RUnlock()
 
-	address = strings.ToLower(dns.Fqdn(address))
-
-	for _, entry := range r.hosts {
-		if r, _ := dns.ReverseAddr(entry.Address.String()); address == r && len(entry.Names) > 0 {
+	entries := r.hosts.byAddress(address)
+	sortHosts(entries)
+	for _, entry := range entries {
+		if len(entry.Names) > 0 {
 			hosts = append(hosts, dns.Fqdn(entry.Names[0]))
 		}
 	}
 	return
 }
 
-func dnsAddressRecord(query *dns.Msg, name string, addrs []net.IP) *dns.Msg {
+func dnsAddressRecord(query *dns.Msg, name string, addrs []net.IP, ttl uint32) *dns.Msg {
 	resp := new(dns.Msg)
 	resp.SetReply(query)
 	for _, addr := range addrs {
 		rr := new(dns.A)
-		rr.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 0}
+		rr.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}
 		rr.A = addr
 
 		resp.Answer = append(resp.Answer, rr)
@@ -244,12 +703,12 @@ func dnsAddressRecord(query *dns.Msg, name string, addrs []net.IP) *dns.Msg {
 	return resp
 }
 
-func dnsPtrRecord(query *dns.Msg, name string, hosts []string) *dns.Msg {
+func dnsPtrRecord(query *dns.Msg, name string, hosts []string, ttl uint32) *dns.Msg {
 	resp := new(dns.Msg)
 	resp.SetReply(query)
 	for _, host := range hosts {
 		rr := new(dns.PTR)
-		rr.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 0}
+		rr.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl}
 		rr.Ptr = host
 
 		resp.Answer = append(resp.Answer, rr)
@@ -263,3 +722,38 @@ func dnsNotFound(query *dns.Msg) *dns.Msg {
 	resp.SetRcode(query, dns.RcodeNameError)
 	return resp
 }
+
+func dnsRefused(query *dns.Msg) *dns.Msg {
+	return dnsRcode(query, dns.RcodeRefused)
+}
+
+func dnsRcode(query *dns.Msg, rcode int) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetReply(query)
+	resp.SetRcode(query, rcode)
+	return resp
+}
+
+func queryName(query *dns.Msg) string {
+	if len(query.Question) == 0 {
+		return "<none>"
+	}
+	return query.Question[0].Name
+}
+
+func queryType(query *dns.Msg) string {
+	if len(query.Question) == 0 {
+		return "<none>"
+	}
+	return dns.TypeToString[query.Question[0].Qtype]
+}
+
+func remoteIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	}
+	return nil
+}
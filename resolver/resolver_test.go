@@ -31,6 +31,55 @@ func TestResolver(t *testing.T) {
 	assertDoesNotResolve(t, hostname, resolver.Port)
 }
 
+func TestListenAddresses(t *testing.T) {
+	address := net.ParseIP("1.2.3.4")
+
+	resolver, err := NewResolver()
+	ok(t, err)
+	resolver.Addresses = []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("127.0.0.2")}
+
+	ok(t, startResolver(resolver))
+	defer resolver.Close()
+
+	resolver.AddHost("foobar", address, "foobar")
+
+	for _, server := range []string{"127.0.0.1", "127.0.0.2"} {
+		addrs, err := lookupHost("foobar", fmt.Sprintf("%s:%d", server, resolver.Port))
+		ok(t, err)
+		equals(t, sortIPs([]net.IP{address}), sortIPs(addrs))
+	}
+}
+
+func TestListenPreopened(t *testing.T) {
+	address := net.ParseIP("1.2.3.4")
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	ok(t, err)
+	listener, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	ok(t, err)
+
+	resolver, err := NewResolver()
+	ok(t, err)
+	resolver.PacketConns = []net.PacketConn{conn}
+	resolver.Listeners = []net.Listener{listener}
+
+	ok(t, resolver.Listen())
+	defer resolver.Close()
+
+	equals(t, conn.LocalAddr().(*net.UDPAddr).Port, resolver.Port)
+
+	resolver.AddHost("foobar", address, "foobar")
+
+	assertResolvesTo(t, []net.IP{address}, "foobar", resolver.Port)
+
+	m := new(dns.Msg)
+	m.SetQuestion("foobar.", dns.TypeA)
+	c := &dns.Client{Net: "tcp"}
+	r, _, err := c.Exchange(m, listener.Addr().String())
+	ok(t, err)
+	equals(t, 1, len(r.Answer))
+}
+
 func TestMultipleAddresses(t *testing.T) {
 	hostname := "foobar"
 	addr1 := net.ParseIP("1.2.3.4")
@@ -66,6 +115,30 @@ func TestUpstreamResolver(t *testing.T) {
 	assertResolvesTo(t, []net.IP{address}, hostname, resolver.Port)
 }
 
+func TestUpstreamFailover(t *testing.T) {
+	address := net.ParseIP("1.2.3.4")
+
+	resolver, err := runResolver()
+	ok(t, err)
+	defer resolver.Close()
+	resolver.SetForwardOptions(200*time.Millisecond, 1)
+
+	// never answers
+	silent, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	ok(t, err)
+	defer silent.Close()
+
+	upstream, err := runResolver()
+	ok(t, err)
+	defer upstream.Close()
+	upstream.AddHost("foobar", address, "foobar")
+
+	resolver.AddUpstream("silent", net.ParseIP("127.0.0.1"), silent.LocalAddr().(*net.UDPAddr).Port)
+	resolver.AddUpstream("upstream", net.ParseIP("127.0.0.1"), upstream.Port)
+
+	assertResolvesTo(t, []net.IP{address}, "foobar", resolver.Port)
+}
+
 func TestUpstreamResolverDomains(t *testing.T) {
 	shouldResolve := net.ParseIP("1.0.0.1")
 	shouldAlsoResolve := net.ParseIP("2.0.0.1")
@@ -141,6 +214,117 @@ func TestLocalDomain(t *testing.T) {
 	assertResolvesTo(t, []net.IP{shouldResolve}, "should-resolve.docker", resolver.Port)
 }
 
+func TestCaseInsensitive(t *testing.T) {
+	address := net.ParseIP("1.2.3.4")
+
+	resolver, err := NewResolver()
+	ok(t, err)
+	resolver.AddUpstream("docker", nil, 0, "docker")
+	resolver.AddHost("web", address, "Web.docker")
+
+	ok(t, startResolver(resolver))
+	defer resolver.Close()
+
+	assertResolvesTo(t, []net.IP{address}, "web.docker", resolver.Port)
+	assertResolvesTo(t, []net.IP{address}, "WEB.DOCKER", resolver.Port)
+
+	// the local domain is not forwarded, whatever the case
+	upstreams := resolver.upstreamsForHost("Other.DOCKER.")
+	equals(t, 1, len(upstreams))
+	equals(t, []string{"docker"}, upstreams[0].Domains)
+}
+
+func TestRecursionNotDesired(t *testing.T) {
+	hostname := "foobar"
+	address := net.ParseIP("1.2.3.4")
+
+	resolver, err := runResolver()
+	ok(t, err)
+	defer resolver.Close()
+
+	upstream, err := runResolver()
+	ok(t, err)
+	defer upstream.Close()
+	upstream.AddHost("foobar", address, hostname)
+
+	resolver.AddHost("local", address, "local.docker")
+	resolver.AddUpstream("upstream", net.ParseIP("127.0.0.1"), upstream.Port)
+
+	r, err := exchange(hostname, dns.TypeA, false, resolver.Port)
+	ok(t, err)
+	equals(t, dns.RcodeRefused, r.Rcode)
+	equals(t, 0, len(r.Answer))
+
+	r, err = exchange("local.docker", dns.TypeA, false, resolver.Port)
+	ok(t, err)
+	equals(t, dns.RcodeSuccess, r.Rcode)
+	equals(t, 1, len(r.Answer))
+}
+
+func TestRecursionAvailable(t *testing.T) {
+	resolver, err := runResolver()
+	ok(t, err)
+	defer resolver.Close()
+
+	r, err := exchange("foobar", dns.TypeA, true, resolver.Port)
+	ok(t, err)
+	equals(t, true, r.RecursionAvailable)
+
+	resolver.SetRecursionNetworks(nil)
+
+	r, err = exchange("foobar", dns.TypeA, true, resolver.Port)
+	ok(t, err)
+	equals(t, false, r.RecursionAvailable)
+}
+
+func TestRecursionNetworks(t *testing.T) {
+	hostname := "foobar"
+	address := net.ParseIP("1.2.3.4")
+
+	resolver, err := runResolver()
+	ok(t, err)
+	defer resolver.Close()
+
+	upstream, err := runResolver()
+	ok(t, err)
+	defer upstream.Close()
+	upstream.AddHost("foobar", address, hostname)
+
+	resolver.AddUpstream("upstream", net.ParseIP("127.0.0.1"), upstream.Port)
+
+	nets, err := ParseNetworks([]string{"10.0.0.0/8"})
+	ok(t, err)
+	resolver.SetRecursionNetworks(nets)
+
+	r, err := exchange(hostname, dns.TypeA, true, resolver.Port)
+	ok(t, err)
+	equals(t, dns.RcodeRefused, r.Rcode)
+
+	nets, err = ParseNetworks([]string{"127.0.0.1"})
+	ok(t, err)
+	resolver.SetRecursionNetworks(nets)
+
+	assertResolvesTo(t, []net.IP{address}, hostname, resolver.Port)
+}
+
+func TestDomains(t *testing.T) {
+	resolver, err := NewResolver()
+	ok(t, err)
+
+	equals(t, []string{}, resolver.Domains())
+
+	resolver.AddUpstream("docker", nil, 0, "docker")
+	resolver.AddUpstream("resolv.conf", net.ParseIP("127.0.0.1"), 53)
+	resolver.AddUpstream("consul", net.ParseIP("127.0.0.2"), 8600, "consul", "Service.Consul.")
+	resolver.AddUpstream("other", net.ParseIP("127.0.0.3"), 53, "consul", "another.domain")
+
+	equals(t, []string{"another.domain", "consul", "service.consul"}, resolver.Domains())
+
+	resolver.RemoveUpstream("other")
+
+	equals(t, []string{"consul", "service.consul"}, resolver.Domains())
+}
+
 func TestReverseLookup(t *testing.T) {
 	addr := net.ParseIP("1.2.3.4")
 
@@ -167,6 +351,33 @@ func TestReverseLookup(t *testing.T) {
 	equals(t, []string{"primary.domain."}, hosts)
 }
 
+func TestCheck(t *testing.T) {
+	resolver, err := NewResolver()
+	ok(t, err)
+
+	if resolver.Check() == nil {
+		t.Fatal("check should fail before listening")
+	}
+
+	ok(t, startResolver(resolver))
+	defer resolver.Close()
+
+	ok(t, resolver.Check())
+}
+
+func TestStats(t *testing.T) {
+	resolver, err := NewResolver()
+	ok(t, err)
+
+	resolver.AddHost("foo", net.ParseIP("1.2.3.4"), "foo")
+	resolver.AddHost("bar", net.ParseIP("1.2.3.5"), "bar")
+	resolver.AddUpstream("upstream", net.ParseIP("127.0.0.1"), 53)
+
+	hosts, upstreams := resolver.Stats()
+	equals(t, 2, hosts)
+	equals(t, 1, upstreams)
+}
+
 func TestWait(t *testing.T) {
 	resolver, err := runResolver()
 	ok(t, err)
@@ -245,6 +456,16 @@ func runResolver() (*dnsResolver, error) {
 	return resolver, err
 }
 
+func exchange(host string, qtype uint16, recursionDesired bool, dnsPort int) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(host), qtype)
+	m.RecursionDesired = recursionDesired
+
+	c := new(dns.Client)
+	r, _, err := c.Exchange(m, fmt.Sprintf("127.0.0.1:%d", dnsPort))
+	return r, err
+}
+
 func lookupHost(host, server string) ([]net.IP, error) {
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(host), dns.TypeA)
@@ -303,3 +524,36 @@ func equals(tb testing.TB, exp, act interface{}) {
 		tb.FailNow()
 	}
 }
+
+func TestRecords(t *testing.T) {
+	resolver, err := NewResolver()
+	ok(t, err)
+
+	changes := 0
+	resolver.OnHostsChanged(func() { changes++ })
+
+	resolver.AddHost("b", net.ParseIP("10.0.0.10"), "b", "b.docker")
+	resolver.AddHost("a", net.ParseIP("10.0.0.9"), "a")
+	resolver.AddHost("c", net.ParseIP("10.0.0.10"), "c")
+	resolver.RemoveHost("c")
+
+	equals(t, 4, changes)
+	equals(t, []HostRecord{
+		{ID: "a", Address: "10.0.0.9", Names: []string{"a"}},
+		{ID: "b", Address: "10.0.0.10", Names: []string{"b", "b.docker"}},
+	}, resolver.Records())
+}
+
+func TestUpstreams(t *testing.T) {
+	resolver, err := NewResolver()
+	ok(t, err)
+
+	resolver.AddUpstream("b", net.ParseIP("10.0.0.2"), 5353, "consul")
+	resolver.AddUpstream("a", net.ParseIP("10.0.0.1"), 53)
+	resolver.AddUpstream("docker", nil, 0, "docker")
+
+	equals(t, []UpstreamRecord{
+		{ID: "b", Address: "10.0.0.2", Port: 5353, Domains: []string{"consul"}},
+		{ID: "a", Address: "10.0.0.1", Port: 53, Domains: []string{}},
+	}, resolver.Upstreams())
+}
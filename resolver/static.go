@@ -0,0 +1,179 @@
+package resolver
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// staticHost prefixes the ids of the hosts loaded from static files, followed
+// by the path and the address.
+const staticHost = "static:"
+
+// FollowHostsFile serves the IPv4 addresses in the /etc/hosts-style file at
+// path, and reloads them whenever the file changes. Names without a dot are
+// also served in domain, unless it is empty. Closing the returned follower
+// stops watching the file and removes its hosts.
+func (r *dnsResolver) FollowHostsFile(path, domain string) (io.Closer, error) {
+	return r.followStatic(path, func(data []byte) (map[string][]string, error) {
+		return parseHostsFile(data, domain), nil
+	})
+}
+
+// FollowZoneFile serves the A records in the RFC 1035 master file at path,
+// with relative names in origin, and reloads them whenever the file changes.
+// Closing the returned follower stops watching the file and removes its
+// hosts.
+func (r *dnsResolver) FollowZoneFile(path, origin string) (io.Closer, error) {
+	return r.followStatic(path, func(data []byte) (map[string][]string, error) {
+		return parseZoneFile(data, path, origin)
+	})
+}
+
+// staticFile registers the hosts in a file under their own ids, so they
+// coexist with those added with AddHost.
+type staticFile struct {
+	resolver *dnsResolver
+	path     string
+	// parse returns the names for each address in the file
+	parse   func(data []byte) (map[string][]string, error)
+	ids     map[string]bool
+	watcher *FileWatcher
+}
+
+func (r *dnsResolver) followStatic(path string, parse func([]byte) (map[string][]string, error)) (io.Closer, error) {
+	f := &staticFile{resolver: r, path: path, parse: parse, ids: make(map[string]bool)}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := WatchFile(path, func() {
+		if err := f.load(); err != nil {
+			log.Println("error reloading static hosts, keeping the current ones:", err)
+		}
+	})
+	if err != nil {
+		f.remove()
+		return nil, err
+	}
+	f.watcher = watcher
+	return f, nil
+}
+
+func (f *staticFile) Close() error {
+	err := f.watcher.Close()
+	f.remove()
+	return err
+}
+
+// load replaces the hosts from a previous load with those currently in the
+// file. The current hosts are kept if the file can't be read or parsed.
+func (f *staticFile) load() error {
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	hosts, err := f.parse(data)
+	if err != nil {
+		return err
+	}
+
+	ids := make(map[string]bool)
+	for _, address := range sortedAddresses(hosts) {
+		id := staticHost + f.path + ":" + address
+		names := hosts[address]
+		f.resolver.AddHost(id, net.ParseIP(address), names[0], names[1:]...)
+		ids[id] = true
+	}
+	for id := range f.ids {
+		if !ids[id] {
+			f.resolver.RemoveHost(id)
+		}
+	}
+	f.ids = ids
+
+	log.Printf("loaded %d static hosts from %s", len(ids), f.path)
+	return nil
+}
+
+func (f *staticFile) remove() {
+	for id := range f.ids {
+		f.resolver.RemoveHost(id)
+	}
+	f.ids = make(map[string]bool)
+}
+
+// parseHostsFile returns the names of each IPv4 address in an /etc/hosts-style
+// file. Other lines, like the IPv6 localhost entries, are skipped.
+func parseHostsFile(data []byte, domain string) map[string][]string {
+	hosts := make(map[string][]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil || ip.To4() == nil {
+			continue
+		}
+
+		address := ip.String()
+		for _, name := range fields[1:] {
+			hosts[address] = appendName(hosts[address], name)
+			if domain != "" && !strings.Contains(name, ".") {
+				hosts[address] = appendName(hosts[address], name+"."+domain)
+			}
+		}
+	}
+	return hosts
+}
+
+// parseZoneFile returns the names of each address in the A records of a zone
+// file. Other records are skipped.
+func parseZoneFile(data []byte, path, origin string) (map[string][]string, error) {
+	hosts := make(map[string][]string)
+
+	parser := dns.NewZoneParser(bytes.NewReader(data), dns.Fqdn(origin), path)
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		switch rr := rr.(type) {
+		case *dns.A:
+			address := rr.A.String()
+			hosts[address] = appendName(hosts[address], strings.TrimSuffix(rr.Hdr.Name, "."))
+		case *dns.SOA, *dns.NS:
+		default:
+			log.Printf("%s: ignoring unsupported %s record for %s", path, dns.TypeToString[rr.Header().Rrtype], rr.Header().Name)
+		}
+	}
+	return hosts, parser.Err()
+}
+
+func appendName(names []string, name string) []string {
+	for _, existing := range names {
+		if strings.EqualFold(existing, name) {
+			return names
+		}
+	}
+	return append(names, name)
+}
+
+func sortedAddresses(hosts map[string][]string) []string {
+	var addresses []string
+	for address := range hosts {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
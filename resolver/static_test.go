@@ -0,0 +1,78 @@
+package resolver
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseHostsFile(t *testing.T) {
+	hosts := parseHostsFile([]byte(`# comment
+127.0.0.1	localhost
+::1	localhost ip6-localhost
+192.168.1.10	nas nas.lan # the NAS
+192.168.1.10	NAS
+bogus	line
+192.168.1.20
+`), "docker")
+
+	equals(t, map[string][]string{
+		"127.0.0.1":    {"localhost", "localhost.docker"},
+		"192.168.1.10": {"nas", "nas.docker", "nas.lan"},
+	}, hosts)
+}
+
+func TestParseZoneFile(t *testing.T) {
+	hosts, err := parseZoneFile([]byte(`$TTL 60
+@	IN	SOA	ns admin 1 3600 600 86400 60
+	IN	NS	ns
+nas	IN	A	192.168.1.10
+vm.example.com.	IN	A	192.168.1.20
+www	IN	CNAME	nas
+`), "test.zone", "docker")
+	ok(t, err)
+
+	equals(t, map[string][]string{
+		"192.168.1.10": {"nas.docker"},
+		"192.168.1.20": {"vm.example.com"},
+	}, hosts)
+
+	_, err = parseZoneFile([]byte("nas IN A not-an-address\n"), "test.zone", "docker")
+	if err == nil {
+		t.Fatal("expected an error for an invalid zone file")
+	}
+}
+
+func TestFollowHostsFile(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "hosts")
+	ok(t, ioutil.WriteFile(path, []byte("192.168.1.10 nas\n192.168.1.20 vm\n"), 0644))
+
+	resolver, err := NewResolver()
+	ok(t, err)
+	resolver.AddHost("container", net.ParseIP("172.17.0.2"), "web.docker")
+
+	follower, err := resolver.FollowHostsFile(path, "docker")
+	ok(t, err)
+
+	equals(t, []net.IP{net.ParseIP("192.168.1.10")}, resolver.findHost("nas.docker."))
+	equals(t, []string{"vm."}, resolver.findReverse("20.1.168.192.in-addr.arpa."))
+
+	ok(t, ioutil.WriteFile(path, []byte("192.168.1.11 nas\n"), 0644))
+	for i := 0; i < 50 && len(resolver.findHost("vm.")) > 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	equals(t, []net.IP{net.ParseIP("192.168.1.11")}, resolver.findHost("nas."))
+	equals(t, 0, len(resolver.findHost("vm.")))
+
+	// closing removes only the static hosts
+	ok(t, follower.Close())
+	equals(t, 0, len(resolver.findHost("nas.")))
+	hosts, _ := resolver.Stats()
+	equals(t, 1, hosts)
+}
@@ -0,0 +1,124 @@
+package resolver
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// hostStore holds the hosts by id, indexed by name, rejected name and reverse
+// address so that queries and conflicts don't scan every host. It is guarded
+// by the resolver's hostMutex.
+type hostStore struct {
+	hosts map[string]*hostsEntry
+	// lower-cased fully qualified names, and reverse addresses like
+	// 4.3.2.1.in-addr.arpa., to the hosts with them by id
+	names   map[string]map[string]*hostsEntry
+	reverse map[string]map[string]*hostsEntry
+	// lower-cased fully qualified names rejected for the hosts
+	rejected map[string]map[string]*hostsEntry
+}
+
+func newHostStore() *hostStore {
+	return &hostStore{
+		hosts:    make(map[string]*hostsEntry),
+		names:    make(map[string]map[string]*hostsEntry),
+		reverse:  make(map[string]map[string]*hostsEntry),
+		rejected: make(map[string]map[string]*hostsEntry),
+	}
+}
+
+func (s *hostStore) len() int {
+	return len(s.hosts)
+}
+
+func (s *hostStore) get(id string) (*hostsEntry, bool) {
+	entry, ok := s.hosts[id]
+	return entry, ok
+}
+
+// add stores a host, replacing any with the same id.
+func (s *hostStore) add(entry *hostsEntry) {
+	s.remove(entry.id)
+
+	s.hosts[entry.id] = entry
+	for _, name := range entry.Names {
+		addIndex(s.names, nameKey(name), entry)
+	}
+	for _, name := range entry.Rejected {
+		addIndex(s.rejected, nameKey(name), entry)
+	}
+	if reverse, ok := reverseKey(entry); ok {
+		addIndex(s.reverse, reverse, entry)
+	}
+}
+
+func (s *hostStore) remove(id string) {
+	entry, ok := s.hosts[id]
+	if !ok {
+		return
+	}
+
+	delete(s.hosts, id)
+	for _, name := range entry.Names {
+		removeIndex(s.names, nameKey(name), id)
+	}
+	for _, name := range entry.Rejected {
+		removeIndex(s.rejected, nameKey(name), id)
+	}
+	if reverse, ok := reverseKey(entry); ok {
+		removeIndex(s.reverse, reverse, id)
+	}
+}
+
+// byName returns the hosts with a name, in no particular order.
+func (s *hostStore) byName(name string) []*hostsEntry {
+	return indexed(s.names, nameKey(name))
+}
+
+// byRejected returns the hosts a name was rejected for, in no particular
+// order.
+func (s *hostStore) byRejected(name string) []*hostsEntry {
+	return indexed(s.rejected, nameKey(name))
+}
+
+// byAddress returns the hosts with the reverse address, in no particular
+// order.
+func (s *hostStore) byAddress(reverse string) []*hostsEntry {
+	return indexed(s.reverse, nameKey(reverse))
+}
+
+func nameKey(name string) string {
+	// names are case-insensitive
+	return strings.ToLower(dns.Fqdn(name))
+}
+
+func reverseKey(entry *hostsEntry) (string, bool) {
+	if entry.Address == nil {
+		return "", false
+	}
+	reverse, err := dns.ReverseAddr(entry.Address.String())
+	return reverse, err == nil
+}
+
+func addIndex(index map[string]map[string]*hostsEntry, key string, entry *hostsEntry) {
+	if index[key] == nil {
+		index[key] = make(map[string]*hostsEntry)
+	}
+	index[key][entry.id] = entry
+}
+
+func removeIndex(index map[string]map[string]*hostsEntry, key, id string) {
+	delete(index[key], id)
+	if len(index[key]) == 0 {
+		delete(index, key)
+	}
+}
+
+func indexed(index map[string]map[string]*hostsEntry, key string) []*hostsEntry {
+	var entries []*hostsEntry
+	for _, entry := range index[key] {
+		entries = append(entries, entry)
+	}
+	return entries
+}
@@ -0,0 +1,57 @@
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	store := newHostStore()
+	store.add(&hostsEntry{id: "a", Address: net.ParseIP("10.0.0.1"), Names: []string{"web", "Web.docker."}})
+	store.add(&hostsEntry{id: "b", Address: net.ParseIP("10.0.0.1"), Names: []string{"db"}, Rejected: []string{"Web"}})
+	equals(t, 2, store.len())
+
+	equals(t, 1, len(store.byName("WEB.DOCKER.")))
+	equals(t, 1, len(store.byName("web.")))
+	equals(t, 2, len(store.byAddress("1.0.0.10.in-addr.arpa.")))
+	equals(t, 1, len(store.byRejected("web.")))
+
+	// adding a host again replaces its names and address
+	store.add(&hostsEntry{id: "a", Address: net.ParseIP("10.0.0.2"), Names: []string{"app"}})
+	equals(t, 0, len(store.byName("web.")))
+	equals(t, 1, len(store.byName("app.")))
+	equals(t, 1, len(store.byAddress("1.0.0.10.in-addr.arpa.")))
+	equals(t, 1, len(store.byAddress("2.0.0.10.in-addr.arpa.")))
+
+	store.remove("a")
+	store.remove("b")
+	equals(t, 0, store.len())
+	equals(t, 0, len(store.names))
+	equals(t, 0, len(store.reverse))
+	equals(t, 0, len(store.rejected))
+}
+
+func TestStoreLookups(t *testing.T) {
+	resolver, err := NewResolver()
+	ok(t, err)
+	for i := 0; i < 1000; i++ {
+		resolver.AddHost(fmt.Sprint(i), net.IPv4(10, 0, byte(i>>8), byte(i)), fmt.Sprintf("host%d", i), fmt.Sprintf("container%d.docker", i))
+	}
+	equals(t, []net.IP{net.IPv4(10, 0, 1, 244)}, resolver.findHost("Container500.docker."))
+	equals(t, []string{"host500."}, resolver.findReverse("244.1.0.10.in-addr.arpa."))
+
+	// a host updated with another address and names is found by them only
+	resolver.AddHost("500", net.ParseIP("10.1.0.1"), "moved", "moved.docker")
+	equals(t, 0, len(resolver.findHost("container500.docker.")))
+	equals(t, 0, len(resolver.findReverse("244.1.0.10.in-addr.arpa.")))
+	equals(t, []net.IP{net.ParseIP("10.1.0.1")}, resolver.findHost("moved.docker."))
+	equals(t, []string{"moved."}, resolver.findReverse("1.0.1.10.in-addr.arpa."))
+
+	// and not at all once removed
+	resolver.RemoveHost("500")
+	equals(t, 0, len(resolver.findHost("moved.docker.")))
+	equals(t, 0, len(resolver.findReverse("1.0.1.10.in-addr.arpa.")))
+	equals(t, 999, resolver.hosts.len())
+	equals(t, []net.IP{net.IPv4(10, 0, 1, 245)}, resolver.findHost("container501.docker."))
+}
@@ -2,7 +2,81 @@
 
 package resolver
 
+// HostResolverConfig configures the host to use resolvable. StoreAddress is
+// called when starting, and again whenever the address changes. Clean is
+// called when resolvable stops. Configs can also implement InfoConfig,
+// MonitoredConfig and StatusConfig; the calls are never made concurrently.
 type HostResolverConfig interface {
 	StoreAddress(address string) error
 	Clean()
 }
+
+// Monitor reports on the health of the running resolvable.
+type Monitor interface {
+	// Check returns an error if resolvable is not answering queries or is no
+	// longer following Docker events.
+	Check() error
+	// Status returns a short summary of what is being served.
+	Status() string
+}
+
+// MonitoredConfig is an optional interface for HostResolverConfigs that
+// supervise the running resolvable, such as the systemd watchdog. Watch is
+// called once the resolver has been created.
+type MonitoredConfig interface {
+	Watch(m Monitor)
+}
+
+// ResolverInfo describes what resolvable is serving, for host resolver
+// configs that need more than the address.
+type ResolverInfo struct {
+	// the address stored in the host configs
+	Address string
+	// the addresses resolvable listens on, none if listening on all addresses
+	Addresses   []string
+	Port        int
+	LocalDomain string
+	// the other domains to route to resolvable: further local domains, and
+	// those forwarded to containers registered with DNS_RESOLVES
+	Domains []string
+	// names of the Docker bridge interfaces containers are attached to
+	Bridges []string
+}
+
+// InfoConfig is an optional interface for HostResolverConfigs, such as those
+// routing only specific domains to resolvable. UpdateInfo is called after
+// StoreAddress, and again whenever the info changes.
+type InfoConfig interface {
+	UpdateInfo(info ResolverInfo) error
+}
+
+// StatusConfig is an optional interface for HostResolverConfigs that can
+// check the host is still configured to use resolvable. Status returns an
+// error describing the problem if not.
+type StatusConfig interface {
+	Status() error
+}
+
+// HostRecord is a host served by resolvable, such as a container or a static
+// host, with the names it is registered with.
+type HostRecord struct {
+	ID      string
+	Address string
+	Names   []string
+}
+
+// UpstreamRecord is an upstream server queries are forwarded to, for the
+// given domains or all others if none.
+type UpstreamRecord struct {
+	ID      string
+	Address string
+	Port    int
+	Domains []string
+}
+
+// RecordsConfig is an optional interface for HostResolverConfigs that export
+// the hosts served, such as to a hosts file. UpdateRecords is called after
+// StoreAddress, and again whenever hosts are added or removed.
+type RecordsConfig interface {
+	UpdateRecords(records []HostRecord) error
+}
@@ -0,0 +1,300 @@
+package resolver
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// updateHost prefixes the ids of the hosts added by dynamic updates, followed
+// by the name and the address.
+const updateHost = "update:"
+
+// updateLease removes a host added by a dynamic update once it expires.
+type updateLease struct {
+	timer *time.Timer
+}
+
+// SetUpdateZones accepts RFC 2136 dynamic updates of the A records in zones,
+// signed with one of keys, which maps the TSIG key names to their base64
+// secrets. The hosts added expire after lease, unless updated again, or never
+// if it is 0. Updates are refused if there are no keys.
+func (r *dnsResolver) SetUpdateZones(zones []string, keys map[string]string, lease time.Duration) error {
+	secrets := make(map[string][]byte)
+	for name, secret := range keys {
+		decoded, err := base64.StdEncoding.DecodeString(secret)
+		if err != nil {
+			return fmt.Errorf("invalid secret of key %s: %s", name, err)
+		}
+		secrets[dns.CanonicalName(name)] = decoded
+	}
+
+	var canonical []string
+	for _, zone := range zones {
+		canonical = append(canonical, dns.CanonicalName(zone))
+	}
+
+	r.tsig.setKeys(secrets)
+
+	r.updateMutex.Lock()
+	defer r.updateMutex.Unlock()
+
+	r.updateZones = canonical
+	r.updateLease = lease
+	return nil
+}
+
+// answerUpdate applies an update message, once its signature is checked. The
+// prerequisites are checked and the updates validated before any is applied.
+func (r *dnsResolver) answerUpdate(update *dns.Msg, tsigStatus error, client net.IP) *dns.Msg {
+	r.updateMutex.Lock()
+	defer r.updateMutex.Unlock()
+
+	if len(update.Question) != 1 || update.Question[0].Qtype != dns.TypeSOA {
+		return dnsRcode(update, dns.RcodeFormatError)
+	}
+	zone := dns.CanonicalName(update.Question[0].Name)
+	if !r.tsig.enabled() || !containsString(r.updateZones, zone) {
+		return dnsRefused(update)
+	}
+
+	tsig := update.IsTsig()
+	if tsig == nil {
+		log.Printf("update from %s: refused, not signed", client)
+		return dnsRefused(update)
+	}
+	if tsigStatus != nil {
+		log.Printf("update from %s: refused, key %s: %s", client, tsig.Hdr.Name, tsigStatus)
+		return dnsRcode(update, dns.RcodeNotAuth)
+	}
+
+	rcode := r.checkPrerequisites(update.Answer, zone)
+	if rcode == dns.RcodeSuccess {
+		rcode = r.checkUpdates(update.Ns, zone)
+	}
+	if rcode == dns.RcodeSuccess {
+		for _, rr := range update.Ns {
+			r.applyUpdate(rr, client)
+		}
+	}
+
+	resp := dnsRcode(update, rcode)
+	resp.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+	return resp
+}
+
+// checkPrerequisites returns the rcode of the first prerequisite not met, only
+// supporting those on whether a name or its A records exist. All the names
+// served are considered, not only those added by updates.
+func (r *dnsResolver) checkPrerequisites(prereqs []dns.RR, zone string) int {
+	for _, rr := range prereqs {
+		hdr := rr.Header()
+		if !dns.IsSubDomain(zone, dns.CanonicalName(hdr.Name)) {
+			return dns.RcodeNotZone
+		}
+		if hdr.Ttl != 0 || hdr.Rdlength != 0 {
+			return dns.RcodeFormatError
+		}
+		exists := len(r.findHost(hdr.Name)) > 0
+		switch {
+		case hdr.Class == dns.ClassANY && (hdr.Rrtype == dns.TypeANY || hdr.Rrtype == dns.TypeA):
+			if !exists && hdr.Rrtype == dns.TypeANY {
+				return dns.RcodeNameError
+			}
+			if !exists {
+				return dns.RcodeNXRrset
+			}
+		case hdr.Class == dns.ClassNONE && (hdr.Rrtype == dns.TypeANY || hdr.Rrtype == dns.TypeA):
+			if exists && hdr.Rrtype == dns.TypeANY {
+				return dns.RcodeYXDomain
+			}
+			if exists {
+				return dns.RcodeYXRrset
+			}
+		default:
+			// prerequisites on the values of records
+			return dns.RcodeNotImplemented
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// checkUpdates returns the rcode for the first update that can't be applied:
+// only A records can be added or deleted, and names deleted.
+func (r *dnsResolver) checkUpdates(updates []dns.RR, zone string) int {
+	for _, rr := range updates {
+		hdr := rr.Header()
+		if !dns.IsSubDomain(zone, dns.CanonicalName(hdr.Name)) {
+			return dns.RcodeNotZone
+		}
+		switch hdr.Class {
+		case dns.ClassINET, dns.ClassNONE:
+			if _, ok := rr.(*dns.A); !ok {
+				return dns.RcodeNotImplemented
+			}
+		case dns.ClassANY:
+			if hdr.Rrtype != dns.TypeANY && hdr.Rrtype != dns.TypeA {
+				return dns.RcodeNotImplemented
+			}
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+func (r *dnsResolver) applyUpdate(rr dns.RR, client net.IP) {
+	hdr := rr.Header()
+	name := strings.TrimSuffix(strings.ToLower(hdr.Name), ".")
+
+	switch hdr.Class {
+	case dns.ClassINET:
+		address := rr.(*dns.A).A
+		r.addUpdated(updateHost+name+":"+address.String(), address, name)
+		log.Printf("update from %s: added %s %s", client, name, address)
+	case dns.ClassNONE:
+		address := rr.(*dns.A).A
+		r.removeUpdated(updateHost + name + ":" + address.String())
+		log.Printf("update from %s: deleted %s %s", client, name, address)
+	case dns.ClassANY:
+		for id := range r.updated {
+			if strings.HasPrefix(id, updateHost+name+":") {
+				r.removeUpdated(id)
+			}
+		}
+		log.Printf("update from %s: deleted %s", client, name)
+	}
+}
+
+// addUpdated adds or renews a host, whose lease restarts.
+func (r *dnsResolver) addUpdated(id string, address net.IP, name string) {
+	if lease := r.updated[id]; lease != nil && lease.timer != nil {
+		lease.timer.Stop()
+	}
+
+	lease := &updateLease{}
+	if r.updateLease > 0 {
+		lease.timer = time.AfterFunc(r.updateLease, func() { r.expireUpdated(id, lease) })
+	}
+	r.updated[id] = lease
+	r.AddHost(id, address, name)
+}
+
+func (r *dnsResolver) removeUpdated(id string) {
+	if lease := r.updated[id]; lease != nil && lease.timer != nil {
+		lease.timer.Stop()
+	}
+	delete(r.updated, id)
+	r.RemoveHost(id)
+}
+
+// expireUpdated removes a host once its lease expires, unless it was renewed.
+func (r *dnsResolver) expireUpdated(id string, lease *updateLease) {
+	r.updateMutex.Lock()
+	defer r.updateMutex.Unlock()
+
+	if r.updated[id] != lease {
+		return
+	}
+	delete(r.updated, id)
+	r.RemoveHost(id)
+	log.Printf("update lease expired: %s", strings.TrimPrefix(id, updateHost))
+}
+
+// acceptMsg accepts update messages, which the default rejects as they can
+// have many records in each section, besides the messages it accepts.
+func acceptMsg(dh dns.Header) dns.MsgAcceptAction {
+	const response = 1 << 15
+	if opcode := int(dh.Bits>>11) & 0xF; opcode == dns.OpcodeUpdate && dh.Bits&response == 0 {
+		if dh.Qdcount != 1 {
+			return dns.MsgReject
+		}
+		return dns.MsgAccept
+	}
+	return dns.DefaultMsgAcceptFunc(dh)
+}
+
+// tsigKeys signs and verifies messages with the keys set with SetUpdateZones,
+// which can change while the servers are running.
+type tsigKeys struct {
+	mutex sync.RWMutex
+	keys  map[string][]byte
+}
+
+func (k *tsigKeys) setKeys(keys map[string][]byte) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.keys = keys
+}
+
+func (k *tsigKeys) enabled() bool {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	return len(k.keys) > 0
+}
+
+func (k *tsigKeys) Generate(msg []byte, t *dns.TSIG) ([]byte, error) {
+	k.mutex.RLock()
+	secret, ok := k.keys[dns.CanonicalName(t.Hdr.Name)]
+	k.mutex.RUnlock()
+	if !ok {
+		return nil, dns.ErrSecret
+	}
+
+	var h func() hash.Hash
+	switch dns.CanonicalName(t.Algorithm) {
+	case dns.HmacSHA1:
+		h = sha1.New
+	case dns.HmacSHA224:
+		h = sha256.New224
+	case dns.HmacSHA256:
+		h = sha256.New
+	case dns.HmacSHA384:
+		h = sha512.New384
+	case dns.HmacSHA512:
+		h = sha512.New
+	default:
+		return nil, dns.ErrKeyAlg
+	}
+	mac := hmac.New(h, secret)
+	mac.Write(msg)
+	return mac.Sum(nil), nil
+}
+
+func (k *tsigKeys) Verify(msg []byte, t *dns.TSIG) error {
+	expected, err := k.Generate(msg, t)
+	if err != nil {
+		return err
+	}
+	mac, err := hex.DecodeString(t.MAC)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(expected, mac) {
+		return dns.ErrSig
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	testKey    = "test."
+	testSecret = "c2VjcmV0IGtleSBmb3IgdGVzdGluZyB1cGRhdGVz"
+)
+
+func sendUpdate(t *testing.T, resolver *dnsResolver, secret string, update *dns.Msg) int {
+	update.SetTsig(testKey, dns.HmacSHA256, 300, time.Now().Unix())
+	c := &dns.Client{TsigSecret: map[string]string{testKey: secret}}
+	resp, _, err := c.Exchange(update, fmt.Sprintf("127.0.0.1:%d", resolver.Port))
+	if err != nil && resp == nil {
+		t.Fatal(err)
+	}
+	return resp.Rcode
+}
+
+func newUpdate(zone string) *dns.Msg {
+	update := new(dns.Msg)
+	update.SetUpdate(dns.Fqdn(zone))
+	return update
+}
+
+func aRecord(t *testing.T, record string) dns.RR {
+	rr, err := dns.NewRR(record)
+	ok(t, err)
+	return rr
+}
+
+func TestUpdate(t *testing.T) {
+	resolver, err := runResolver()
+	ok(t, err)
+	defer resolver.Close()
+	ok(t, resolver.SetUpdateZones([]string{"docker"}, map[string]string{testKey: testSecret}, 0))
+	resolver.AddHost("container", net.ParseIP("172.17.0.2"), "web.docker")
+
+	update := newUpdate("docker")
+	update.Insert([]dns.RR{aRecord(t, "fake.docker. 60 IN A 10.0.0.1"), aRecord(t, "fake.docker. 60 IN A 10.0.0.2")})
+	equals(t, dns.RcodeSuccess, sendUpdate(t, resolver, testSecret, update))
+	assertResolvesTo(t, []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}, "fake.docker", resolver.Port)
+
+	update = newUpdate("docker")
+	update.Remove([]dns.RR{aRecord(t, "fake.docker. IN A 10.0.0.1")})
+	equals(t, dns.RcodeSuccess, sendUpdate(t, resolver, testSecret, update))
+	assertResolvesTo(t, []net.IP{net.ParseIP("10.0.0.2")}, "fake.docker", resolver.Port)
+
+	// the prerequisites are checked before anything is updated
+	update = newUpdate("docker")
+	update.NameNotUsed([]dns.RR{aRecord(t, "web.docker. IN A 0.0.0.0")})
+	update.RemoveName([]dns.RR{aRecord(t, "fake.docker. IN A 0.0.0.0")})
+	equals(t, dns.RcodeYXDomain, sendUpdate(t, resolver, testSecret, update))
+	assertResolvesTo(t, []net.IP{net.ParseIP("10.0.0.2")}, "fake.docker", resolver.Port)
+
+	update = newUpdate("docker")
+	update.NameUsed([]dns.RR{aRecord(t, "web.docker. IN A 0.0.0.0")})
+	update.RemoveName([]dns.RR{aRecord(t, "fake.docker. IN A 0.0.0.0")})
+	equals(t, dns.RcodeSuccess, sendUpdate(t, resolver, testSecret, update))
+	assertDoesNotResolve(t, "fake.docker", resolver.Port)
+
+	// only the hosts added by updates are deleted
+	update = newUpdate("docker")
+	update.RemoveName([]dns.RR{aRecord(t, "web.docker. IN A 0.0.0.0")})
+	equals(t, dns.RcodeSuccess, sendUpdate(t, resolver, testSecret, update))
+	assertResolvesTo(t, []net.IP{net.ParseIP("172.17.0.2")}, "web.docker", resolver.Port)
+}
+
+func TestUpdateRefused(t *testing.T) {
+	resolver, err := runResolver()
+	ok(t, err)
+	defer resolver.Close()
+
+	update := newUpdate("docker")
+	update.Insert([]dns.RR{aRecord(t, "fake.docker. 60 IN A 10.0.0.1")})
+
+	// without keys, updates are disabled
+	equals(t, dns.RcodeRefused, sendUpdate(t, resolver, testSecret, update.Copy()))
+
+	ok(t, resolver.SetUpdateZones([]string{"docker"}, map[string]string{testKey: testSecret}, 0))
+	equals(t, dns.RcodeNotAuth, sendUpdate(t, resolver, "b3RoZXIgc2VjcmV0", update.Copy()))
+
+	unsigned := update.Copy()
+	c := new(dns.Client)
+	resp, _, err := c.Exchange(unsigned, fmt.Sprintf("127.0.0.1:%d", resolver.Port))
+	ok(t, err)
+	equals(t, dns.RcodeRefused, resp.Rcode)
+
+	other := newUpdate("example.com")
+	other.Insert([]dns.RR{aRecord(t, "fake.example.com. 60 IN A 10.0.0.1")})
+	equals(t, dns.RcodeRefused, sendUpdate(t, resolver, testSecret, other))
+
+	outside := newUpdate("docker")
+	outside.Insert([]dns.RR{aRecord(t, "fake.example.com. 60 IN A 10.0.0.1")})
+	equals(t, dns.RcodeNotZone, sendUpdate(t, resolver, testSecret, outside))
+
+	cname := newUpdate("docker")
+	cname.Insert([]dns.RR{aRecord(t, "www.docker. 60 IN CNAME web.docker.")})
+	equals(t, dns.RcodeNotImplemented, sendUpdate(t, resolver, testSecret, cname))
+
+	assertDoesNotResolve(t, "fake.docker", resolver.Port)
+}
+
+func TestUpdateLease(t *testing.T) {
+	resolver, err := runResolver()
+	ok(t, err)
+	defer resolver.Close()
+	ok(t, resolver.SetUpdateZones([]string{"docker"}, map[string]string{testKey: testSecret}, 200*time.Millisecond))
+
+	update := newUpdate("docker")
+	update.Insert([]dns.RR{aRecord(t, "fake.docker. 60 IN A 10.0.0.1")})
+	equals(t, dns.RcodeSuccess, sendUpdate(t, resolver, testSecret, update.Copy()))
+	assertResolvesTo(t, []net.IP{net.ParseIP("10.0.0.1")}, "fake.docker", resolver.Port)
+
+	// renewing restarts the lease
+	time.Sleep(120 * time.Millisecond)
+	equals(t, dns.RcodeSuccess, sendUpdate(t, resolver, testSecret, update.Copy()))
+	time.Sleep(120 * time.Millisecond)
+	assertResolvesTo(t, []net.IP{net.ParseIP("10.0.0.1")}, "fake.docker", resolver.Port)
+
+	time.Sleep(200 * time.Millisecond)
+	assertDoesNotResolve(t, "fake.docker", resolver.Port)
+}
@@ -0,0 +1,142 @@
+package resolver
+
+import (
+	"io"
+	"log"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// defaultForwardTimeout is used until SetForwardOptions is called.
+const defaultForwardTimeout = 2 * time.Second
+
+// resolvConfUpstream prefixes the ids of the upstream servers from resolv.conf.
+const resolvConfUpstream = "resolv.conf:"
+
+// FollowResolvConf forwards queries to the nameservers in the resolv.conf at
+// path, honoring its timeout and attempts options for them only, and updates the servers
+// whenever the file changes. Servers for which ignore returns true, like the
+// address of resolvable itself, are skipped. Closing the returned follower
+// stops watching the file and removes its servers.
+func (r *dnsResolver) FollowResolvConf(path string, ignore func(server string) bool) (io.Closer, error) {
+	if err := r.loadResolvConf(path, ignore); err != nil {
+		return nil, err
+	}
+
+	watcher, err := WatchFile(path, func() {
+		if err := r.loadResolvConf(path, ignore); err != nil {
+			log.Println("error reloading upstream servers:", err)
+		}
+	})
+	if err != nil {
+		r.removeResolvConf()
+		return nil, err
+	}
+	return &resolvConfFollower{resolver: r, watcher: watcher}, nil
+}
+
+type resolvConfFollower struct {
+	resolver *dnsResolver
+	watcher  *FileWatcher
+}
+
+func (f *resolvConfFollower) Close() error {
+	err := f.watcher.Close()
+	f.resolver.removeResolvConf()
+	return err
+}
+
+// removeResolvConf removes the upstream servers loaded from resolv.conf.
+func (r *dnsResolver) removeResolvConf() {
+	r.upstreamMutex.Lock()
+	defer r.upstreamMutex.Unlock()
+
+	for _, server := range r.resolvConfUpstreams() {
+		delete(r.upstream, resolvConfUpstream+server)
+	}
+}
+
+// loadResolvConf replaces the upstream servers from a previous load with
+// those currently in the file at path.
+func (r *dnsResolver) loadResolvConf(path string, ignore func(server string) bool) error {
+	config, err := dns.ClientConfigFromFile(path)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(config.Port)
+	if err != nil {
+		return err
+	}
+
+	var servers []string
+	for _, server := range config.Servers {
+		if ignore != nil && ignore(server) {
+			continue
+		}
+		ip := net.ParseIP(server)
+		if ip == nil {
+			log.Println("ignoring unsupported upstream server in resolv.conf:", server)
+			continue
+		}
+		servers = append(servers, ip.String())
+	}
+
+	// the options only apply to the servers from the file
+	timeout, attempts := time.Duration(config.Timeout)*time.Second, config.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	r.upstreamMutex.Lock()
+	defer r.upstreamMutex.Unlock()
+
+	current := r.resolvConfUpstreams()
+	if reflect.DeepEqual(current, servers) && r.resolvConfOptions(timeout, attempts) {
+		return nil
+	}
+
+	for _, server := range current {
+		delete(r.upstream, resolvConfUpstream+server)
+	}
+	// added again in order, so they are tried in the order they are listed
+	for _, server := range servers {
+		r.upstreamSeq++
+		r.upstream[resolvConfUpstream+server] = &serversEntry{Address: net.ParseIP(server), Port: port, seq: r.upstreamSeq, timeout: timeout, attempts: attempts}
+	}
+
+	log.Printf("upstream servers from resolv.conf: %v, were: %v", servers, current)
+	return nil
+}
+
+// resolvConfOptions returns whether the servers loaded from resolv.conf have
+// the given options. The caller must hold upstreamMutex.
+func (r *dnsResolver) resolvConfOptions(timeout time.Duration, attempts int) bool {
+	for id, upstream := range r.upstream {
+		if strings.HasPrefix(id, resolvConfUpstream) && (upstream.timeout != timeout || upstream.attempts != attempts) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolvConfUpstreams returns the servers loaded from resolv.conf in the order
+// they are tried. The caller must hold upstreamMutex.
+func (r *dnsResolver) resolvConfUpstreams() []string {
+	var entries []*serversEntry
+	var servers []string
+	for id, upstream := range r.upstream {
+		if strings.HasPrefix(id, resolvConfUpstream) {
+			entries = append(entries, upstream)
+		}
+	}
+	sortServers(entries)
+	for _, upstream := range entries {
+		servers = append(servers, upstream.Address.String())
+	}
+	return servers
+}
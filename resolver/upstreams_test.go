@@ -0,0 +1,63 @@
+package resolver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFollowResolvConf(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "resolv.conf")
+	conf := "nameserver 8.8.8.8\nnameserver 1.2.3.4\nnameserver 8.8.4.4\noptions timeout:3 attempts:4\n"
+	ok(t, ioutil.WriteFile(path, []byte(conf), 0644))
+
+	resolver, err := NewResolver()
+	ok(t, err)
+
+	watcher, err := resolver.FollowResolvConf(path, func(server string) bool {
+		return server == "1.2.3.4"
+	})
+	ok(t, err)
+
+	equals(t, []string{"8.8.8.8", "8.8.4.4"}, resolver.resolvConfUpstreams())
+	equals(t, 3*time.Second, resolver.upstream["resolv.conf:8.8.8.8"].timeout)
+	equals(t, 4, resolver.upstream["resolv.conf:8.8.8.8"].attempts)
+	// the other servers keep the defaults
+	equals(t, defaultForwardTimeout, resolver.forwardTimeout)
+	equals(t, 1, resolver.forwardAttempts)
+
+	// other upstreams are left alone
+	resolver.AddUpstream("container", nil, 53, "consul")
+
+	ok(t, ioutil.WriteFile(path, []byte("nameserver 8.8.4.4\nnameserver 9.9.9.9\n"), 0644))
+
+	servers := func() []string {
+		resolver.upstreamMutex.RLock()
+		defer resolver.upstreamMutex.RUnlock()
+		return resolver.resolvConfUpstreams()
+	}
+	for i := 0; i < 50 && servers()[0] != "8.8.4.4"; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	equals(t, []string{"8.8.4.4", "9.9.9.9"}, servers())
+	resolver.upstreamMutex.RLock()
+	equals(t, 5*time.Second, resolver.upstream["resolv.conf:9.9.9.9"].timeout)
+	equals(t, 2, resolver.upstream["resolv.conf:9.9.9.9"].attempts)
+	resolver.upstreamMutex.RUnlock()
+	equals(t, defaultForwardTimeout, resolver.forwardTimeout)
+
+	_, upstreams := resolver.Stats()
+	equals(t, 3, upstreams)
+
+	// closing removes only the servers from resolv.conf
+	ok(t, watcher.Close())
+	equals(t, 0, len(servers()))
+	_, upstreams = resolver.Stats()
+	equals(t, 1, upstreams)
+}
@@ -0,0 +1,77 @@
+package resolver
+
+import (
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDelay collects the events of a rewrite into a single change.
+const watchDelay = 100 * time.Millisecond
+
+// FileWatcher calls a function whenever a file is changed.
+type FileWatcher struct {
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+// WatchFile calls onChange after path is written, replaced or removed. Both
+// the file and its directory are watched, to notice writes to a bind-mounted
+// file as well as a file being replaced with a rename.
+func WatchFile(path string, onChange func()) (*FileWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	path = filepath.Clean(path)
+	if err = watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	// the file may not exist yet, the directory watch notices it is created
+	watcher.Add(path)
+
+	w := &FileWatcher{watcher: watcher, done: make(chan struct{})}
+	go w.run(path, onChange)
+	return w, nil
+}
+
+func (w *FileWatcher) run(path string, onChange func()) {
+	defer close(w.done)
+
+	var changed <-chan time.Time
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != path || event.Op == fsnotify.Chmod {
+				continue
+			}
+			if event.Op&fsnotify.Create != 0 {
+				// follow the new file when it was replaced
+				w.watcher.Add(path)
+			}
+			changed = time.After(watchDelay)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Println("watch:", err)
+		case <-changed:
+			changed = nil
+			onChange()
+		}
+	}
+}
+
+// Close stops watching, and waits for a running onChange to return.
+func (w *FileWatcher) Close() error {
+	err := w.watcher.Close()
+	<-w.done
+	return err
+}
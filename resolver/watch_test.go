@@ -0,0 +1,58 @@
+package resolver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchFile(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "watched")
+	if err := ioutil.WriteFile(path, []byte("one\n"), 0644); err != nil {
+		t.Fatal("could not create file:", err)
+	}
+
+	changes := make(chan struct{}, 10)
+	watcher, err := WatchFile(path, func() { changes <- struct{}{} })
+	if err != nil {
+		t.Fatal("could not watch file:", err)
+	}
+	defer watcher.Close()
+
+	expectChange := func(what string) {
+		select {
+		case <-changes:
+		case <-time.After(time.Second):
+			t.Fatal("no change seen after", what)
+		}
+	}
+
+	if err = ioutil.WriteFile(path, []byte("two\n"), 0644); err != nil {
+		t.Fatal("could not write file:", err)
+	}
+	expectChange("write")
+
+	tmp := filepath.Join(dir, "replacement")
+	if err = ioutil.WriteFile(tmp, []byte("three\n"), 0644); err != nil {
+		t.Fatal("could not create file:", err)
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		t.Fatal("could not replace file:", err)
+	}
+	expectChange("rename")
+
+	// other files in the directory are ignored
+	if err = ioutil.WriteFile(filepath.Join(dir, "other"), []byte("four\n"), 0644); err != nil {
+		t.Fatal("could not create file:", err)
+	}
+	select {
+	case <-changes:
+		t.Error("unexpected change for another file")
+	case <-time.After(3 * watchDelay):
+	}
+}
@@ -0,0 +1,426 @@
+// Package settings reads the options of resolvable and its modules from a
+// TOML config file, the environment and command line flags.
+//
+// Each option is named after its environment variable, e.g. LISTEN_ADDRS. In
+// the config file it is written in lower case, listen_addrs, optionally
+// split into tables: nm = { pid_file = "..." } sets NM_PID_FILE.
+// As a flag it is written --listen-addrs=... Flags take precedence over the
+// environment, which takes precedence over the config file.
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joeshaw/multierror"
+)
+
+// ConfigName is the setting with the path to the config file, which can only
+// be set in the environment or with the --config flag.
+const ConfigName = "RESOLVABLE_CONFIG"
+
+// DefaultConfig is read if it exists and no other config file is set.
+const DefaultConfig = "/config/resolvable.toml"
+
+// redacted is shown instead of the values of secret settings.
+const redacted = "<redacted>"
+
+type setting struct {
+	name  string
+	def   string
+	usage string
+	// parse checks the value has the type of the setting
+	parse func(value string) error
+	check func(value string) error
+	// secret values are redacted when printed or reported
+	secret bool
+	// required settings can't be set to an empty value
+	required bool
+}
+
+// Change is a setting whose value was changed by Reload.
+type Change struct {
+	Name, Old, New string
+}
+
+// Settings holds the registered settings and the values they are set to.
+type Settings struct {
+	mutex    sync.Mutex
+	settings map[string]*setting
+
+	args    []string
+	getenv  func(string) string
+	loaded  bool
+	loadErr multierror.Errors
+	path    string
+	file    map[string]string
+	flags   map[string]string
+	rest    []string
+}
+
+// New returns settings read from the given command line arguments and
+// environment, loaded when the first setting is registered.
+func New(args []string, getenv func(string) string) *Settings {
+	return &Settings{
+		settings: make(map[string]*setting),
+		args:     args,
+		getenv:   getenv,
+	}
+}
+
+// std are the settings of the running process.
+var std = New(os.Args[1:], os.Getenv)
+
+func String(name, def, usage string) string { return std.String(name, def, usage) }
+
+func Bool(name string, def bool, usage string) bool { return std.Bool(name, def, usage) }
+
+func Int(name string, def int, usage string) int { return std.Int(name, def, usage) }
+
+func List(name, usage string) []string { return std.List(name, usage) }
+
+func Check(name string, check func(value string) error) { std.Check(name, check) }
+
+func Secret(name string) { std.Secret(name) }
+
+func Required(name string) { std.Required(name) }
+
+func Validate() error { return std.Validate() }
+
+func Reload() ([]Change, error) { return std.Reload() }
+
+func Print(w io.Writer) { std.Print(w) }
+
+func Args() []string { return std.Args() }
+
+// String registers a setting, and returns its value.
+func (s *Settings) String(name, def, usage string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.load()
+	if _, ok := s.settings[name]; !ok {
+		s.settings[name] = &setting{name: name, def: def, usage: usage}
+	}
+	value, _ := s.lookup(name)
+	return value
+}
+
+// Bool registers a setting for a boolean, and returns its value. An invalid
+// value is reported by Validate, and the default is used instead.
+func (s *Settings) Bool(name string, def bool, usage string) bool {
+	value, err := strconv.ParseBool(s.String(name, strconv.FormatBool(def), usage))
+	s.setParse(name, func(value string) error {
+		if _, err := strconv.ParseBool(value); err != nil {
+			return errors.New("expected true or false")
+		}
+		return nil
+	})
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// Int registers a setting for an integer, and returns its value. An invalid
+// value is reported by Validate, and the default is used instead.
+func (s *Settings) Int(name string, def int, usage string) int {
+	value, err := strconv.Atoi(s.String(name, strconv.Itoa(def), usage))
+	s.setParse(name, func(value string) error {
+		if _, err := strconv.Atoi(value); err != nil {
+			return errors.New("expected a number")
+		}
+		return nil
+	})
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// List registers a setting for a comma-separated list, or an array in the
+// config file, and returns its values.
+func (s *Settings) List(name, usage string) []string {
+	var values []string
+	for _, value := range strings.Split(s.String(name, "", usage), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// Check sets the check of the value of a registered setting, run by Validate
+// and Reload, replacing any previous one. Empty values are not checked.
+func (s *Settings) Check(name string, check func(value string) error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if setting, ok := s.settings[name]; ok {
+		setting.check = check
+	}
+}
+
+// Secret marks a registered setting as holding a secret, whose value is
+// redacted by Print, in the changes returned by Reload and in errors.
+func (s *Settings) Secret(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if setting, ok := s.settings[name]; ok {
+		setting.secret = true
+	}
+}
+
+// Required marks a registered setting that can't be set to an empty value,
+// which Validate and Reload report.
+func (s *Settings) Required(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if setting, ok := s.settings[name]; ok {
+		setting.required = true
+	}
+}
+
+func (s *Settings) setParse(name string, parse func(value string) error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.settings[name].parse = parse
+}
+
+// Validate returns the problems with the config file and flags, settings that
+// are not known, and values that are invalid.
+func (s *Settings) Validate() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.load()
+	return s.validate()
+}
+
+// Reload reads the config file again, and returns the settings whose values
+// changed. If the new config is not valid, the problems are returned and the
+// previous config is kept. Flags and the environment can't change while
+// running, so they still take precedence.
+func (s *Settings) Reload() ([]Change, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.load()
+	old := make(map[string]string)
+	for name := range s.settings {
+		old[name], _ = s.lookup(name)
+	}
+	oldFile, oldErr := s.file, s.loadErr
+
+	s.file, s.loadErr = nil, nil
+	s.readFile()
+	if err := s.validate(); err != nil {
+		s.file, s.loadErr = oldFile, oldErr
+		return nil, err
+	}
+
+	var changes []Change
+	for _, name := range s.names() {
+		if value, _ := s.lookup(name); value != old[name] {
+			changes = append(changes, Change{Name: name, Old: s.display(name, old[name]), New: s.display(name, value)})
+		}
+	}
+	return changes, nil
+}
+
+func (s *Settings) validate() error {
+	errs := append(multierror.Errors{}, s.loadErr...)
+
+	for _, name := range sortedKeys(s.file) {
+		if _, ok := s.settings[name]; !ok {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %s", s.path, strings.ToLower(name)))
+		}
+	}
+	for _, name := range sortedKeys(s.flags) {
+		if _, ok := s.settings[name]; !ok && name != ConfigName {
+			errs = append(errs, fmt.Errorf("unknown flag --%s", flagName(name)))
+		}
+	}
+
+	for _, name := range s.names() {
+		value, source := s.lookup(name)
+		setting := s.settings[name]
+		if value == "" {
+			if setting.required {
+				errs = append(errs, fmt.Errorf("%s: empty value from %s, must be set", name, source))
+			}
+			continue
+		}
+		for _, check := range []func(string) error{setting.parse, setting.check} {
+			if check == nil {
+				continue
+			}
+			if err := check(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid value %q from %s: %s", name, s.display(name, value), source, err))
+				break
+			}
+		}
+	}
+
+	return errs.Err()
+}
+
+// Print writes the value of each setting, in the format of the config file.
+func (s *Settings) Print(w io.Writer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, name := range s.names() {
+		setting := s.settings[name]
+		value, source := s.lookup(name)
+		fmt.Fprintf(w, "# %s\n%s = %q # %s\n\n", setting.usage, strings.ToLower(name), s.display(name, value), source)
+	}
+}
+
+// Args returns the command line arguments that are not flags.
+func (s *Settings) Args() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.load()
+	return s.rest
+}
+
+func (s *Settings) names() []string {
+	var names []string
+	for name := range s.settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// display returns the value of a setting to show, redacted if it is secret.
+func (s *Settings) display(name, value string) string {
+	if setting, ok := s.settings[name]; ok && setting.secret && value != "" {
+		return redacted
+	}
+	return value
+}
+
+// lookup returns the value of a setting, and where it was set.
+func (s *Settings) lookup(name string) (value, source string) {
+	if value, ok := s.flags[name]; ok {
+		return value, "flag"
+	}
+	if value := s.getenv(name); value != "" {
+		return value, "environment"
+	}
+	if value, ok := s.file[name]; ok {
+		return value, s.path
+	}
+	if setting, ok := s.settings[name]; ok {
+		return setting.def, "default"
+	}
+	return "", "default"
+}
+
+// load parses the flags and reads the config file, the first time it is
+// called. Problems are returned by Validate.
+func (s *Settings) load() {
+	if s.loaded {
+		return
+	}
+	s.loaded = true
+
+	s.flags = make(map[string]string)
+	for _, arg := range s.args {
+		if !strings.HasPrefix(arg, "--") {
+			s.rest = append(s.rest, arg)
+			continue
+		}
+		keyVal := strings.SplitN(arg[2:], "=", 2)
+		name := settingName(keyVal[0])
+		if name == "CONFIG" {
+			name = ConfigName
+		}
+		if len(keyVal) > 1 {
+			s.flags[name] = keyVal[1]
+		} else {
+			s.flags[name] = "true"
+		}
+	}
+
+	s.path = s.flags[ConfigName]
+	if s.path == "" {
+		s.path = s.getenv(ConfigName)
+	}
+	if s.path == "" {
+		if _, err := os.Stat(DefaultConfig); err != nil {
+			return
+		}
+		s.path = DefaultConfig
+	}
+	s.readFile()
+}
+
+// readFile reads the config file, if there is one.
+func (s *Settings) readFile() {
+	if s.path == "" {
+		return
+	}
+
+	var file map[string]interface{}
+	if _, err := toml.DecodeFile(s.path, &file); err != nil {
+		s.loadErr = append(s.loadErr, fmt.Errorf("%s: %s", s.path, err))
+		return
+	}
+	s.file = make(map[string]string)
+	flatten(s.file, "", file)
+}
+
+// flatten stores the values in a config file by setting name, joining the
+// names of nested tables with underscores and arrays with commas.
+func flatten(values map[string]string, prefix string, table map[string]interface{}) {
+	for key, value := range table {
+		name := settingName(key)
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+
+		switch value := value.(type) {
+		case map[string]interface{}:
+			flatten(values, name, value)
+		case []interface{}:
+			var items []string
+			for _, item := range value {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[name] = strings.Join(items, ",")
+		default:
+			values[name] = fmt.Sprint(value)
+		}
+	}
+}
+
+func settingName(key string) string {
+	return strings.ToUpper(strings.Replace(key, "-", "_", -1))
+}
+
+func flagName(name string) string {
+	return strings.ToLower(strings.Replace(name, "_", "-", -1))
+}
+
+func sortedKeys(values map[string]string) []string {
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,237 @@
+package settings
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func tempConfig(t *testing.T, config string) (string, func()) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("could not create temp dir:", err)
+	}
+	path := filepath.Join(dir, "resolvable.toml")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal("could not create file:", err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func env(values map[string]string) func(string) string {
+	return func(name string) string { return values[name] }
+}
+
+func TestPrecedence(t *testing.T) {
+	path, cleanup := tempConfig(t, `
+listen_port = 5353
+local_domain = "file"
+record_ttl = 10
+listen_addrs = ["127.0.0.1", "127.0.0.2"]
+
+[nm]
+pid_file = "/run/nm.pid"
+`)
+	defer cleanup()
+
+	s := New([]string{"--config=" + path, "--local-domain=flag", "--search", "check-config"}, env(map[string]string{
+		"LOCAL_DOMAIN": "env",
+		"RECORD_TTL":   "20",
+	}))
+
+	if port := s.Int("LISTEN_PORT", 53, ""); port != 5353 {
+		t.Errorf("expected port from file, got %d", port)
+	}
+	if ttl := s.Int("RECORD_TTL", 0, ""); ttl != 20 {
+		t.Errorf("expected TTL from environment, got %d", ttl)
+	}
+	if domain := s.String("LOCAL_DOMAIN", "docker", ""); domain != "flag" {
+		t.Errorf("expected domain from flag, got %q", domain)
+	}
+	if search := s.Bool("SEARCH", false, ""); !search {
+		t.Error("expected flag without a value to be true")
+	}
+	if pidFile := s.String("NM_PID_FILE", "", ""); pidFile != "/run/nm.pid" {
+		t.Errorf("expected value from table, got %q", pidFile)
+	}
+	if addrs := s.List("LISTEN_ADDRS", ""); !reflect.DeepEqual(addrs, []string{"127.0.0.1", "127.0.0.2"}) {
+		t.Errorf("expected list from array, got %v", addrs)
+	}
+	if dockerHost := s.String("DOCKER_HOST", "unix:///tmp/docker.sock", ""); dockerHost != "unix:///tmp/docker.sock" {
+		t.Errorf("expected default, got %q", dockerHost)
+	}
+	if args := s.Args(); !reflect.DeepEqual(args, []string{"check-config"}) {
+		t.Errorf("expected command in args, got %v", args)
+	}
+
+	if err := s.Validate(); err != nil {
+		t.Error("expected valid settings, got:", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	path, cleanup := tempConfig(t, `
+listen_port = "http"
+listen_addrs = ["bogus"]
+unknown_setting = true
+`)
+	defer cleanup()
+
+	s := New([]string{"--config=" + path, "--unknown-flag=1"}, env(nil))
+	s.Int("LISTEN_PORT", 53, "")
+	s.List("LISTEN_ADDRS", "")
+	s.Check("LISTEN_ADDRS", func(value string) error {
+		return os.ErrInvalid
+	})
+
+	err := s.Validate()
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	for _, expected := range []string{"LISTEN_PORT", "LISTEN_ADDRS", "unknown_setting", "--unknown-flag"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected an error for %s, got: %s", expected, err)
+		}
+	}
+}
+
+func TestMissingConfig(t *testing.T) {
+	s := New(nil, env(map[string]string{ConfigName: "/nonexistent/resolvable.toml"}))
+	s.String("LOCAL_DOMAIN", "docker", "")
+
+	if err := s.Validate(); err == nil {
+		t.Error("expected an error for a missing config file")
+	}
+}
+
+func TestPrint(t *testing.T) {
+	s := New([]string{"--local-domain=flag"}, env(nil))
+	s.String("LOCAL_DOMAIN", "docker", "domain of the container names")
+	s.Int("LISTEN_PORT", 53, "port to listen on")
+
+	var buf bytes.Buffer
+	s.Print(&buf)
+
+	expected := "# port to listen on\nlisten_port = \"53\" # default\n\n" +
+		"# domain of the container names\nlocal_domain = \"flag\" # flag\n\n"
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\nbut got:\n%s", expected, buf.String())
+	}
+}
+
+func TestReload(t *testing.T) {
+	path, cleanup := tempConfig(t, `
+record_ttl = 10
+upstreams = ["8.8.8.8"]
+local_domain = "file"
+`)
+	defer cleanup()
+
+	s := New([]string{"--config=" + path}, env(map[string]string{"LOCAL_DOMAIN": "env"}))
+	s.Int("RECORD_TTL", 0, "")
+	s.List("UPSTREAMS", "")
+	s.String("LOCAL_DOMAIN", "docker", "")
+
+	err := ioutil.WriteFile(path, []byte(`
+record_ttl = 30
+upstreams = ["8.8.8.8"]
+local_domain = "changed"
+`), 0644)
+	if err != nil {
+		t.Fatal("could not write file:", err)
+	}
+
+	changes, err := s.Reload()
+	if err != nil {
+		t.Fatal("expected valid config, got:", err)
+	}
+	// the domain from the environment still takes precedence
+	expected := []Change{{Name: "RECORD_TTL", Old: "10", New: "30"}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes %v, got %v", expected, changes)
+	}
+	if ttl := s.Int("RECORD_TTL", 0, ""); ttl != 30 {
+		t.Errorf("expected reloaded TTL, got %d", ttl)
+	}
+}
+
+func TestReloadInvalid(t *testing.T) {
+	path, cleanup := tempConfig(t, "record_ttl = 10\n")
+	defer cleanup()
+
+	s := New([]string{"--config=" + path}, env(nil))
+	s.Int("RECORD_TTL", 0, "")
+
+	for _, config := range []string{"record_ttl = \"soon\"\n", "record_ttl = 30\nunknown_setting = 1\n", "record_ttl = [\n"} {
+		if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal("could not write file:", err)
+		}
+		if _, err := s.Reload(); err == nil {
+			t.Errorf("expected an error reloading %q", config)
+		}
+		if ttl := s.Int("RECORD_TTL", 0, ""); ttl != 10 {
+			t.Errorf("expected previous TTL to be kept, got %d", ttl)
+		}
+	}
+	if err := s.Validate(); err != nil {
+		t.Error("expected previous config to still be valid, got:", err)
+	}
+}
+
+func TestSecret(t *testing.T) {
+	path, cleanup := tempConfig(t, "update_keys = [\"key:c2VjcmV0\"]\n")
+	defer cleanup()
+
+	s := New([]string{"--config=" + path}, env(nil))
+	s.List("UPDATE_KEYS", "keys")
+	s.Secret("UPDATE_KEYS")
+	s.Check("UPDATE_KEYS", func(value string) error {
+		if strings.Contains(value, "invalid") {
+			return errors.New("invalid key")
+		}
+		return nil
+	})
+
+	var buf bytes.Buffer
+	s.Print(&buf)
+	if strings.Contains(buf.String(), "c2VjcmV0") || !strings.Contains(buf.String(), `update_keys = "<redacted>"`) {
+		t.Errorf("expected secret to be redacted, got:\n%s", buf.String())
+	}
+
+	if err := ioutil.WriteFile(path, []byte("update_keys = [\"key:bmV3\"]\n"), 0644); err != nil {
+		t.Fatal("could not write file:", err)
+	}
+	changes, err := s.Reload()
+	if err != nil {
+		t.Fatal("expected valid config, got:", err)
+	}
+	expected := []Change{{Name: "UPDATE_KEYS", Old: "<redacted>", New: "<redacted>"}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes %v, got %v", expected, changes)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("update_keys = [\"invalid:bmV3\"]\n"), 0644); err != nil {
+		t.Fatal("could not write file:", err)
+	}
+	if _, err := s.Reload(); err == nil || strings.Contains(err.Error(), "bmV3") {
+		t.Errorf("expected an error without the secret, got %v", err)
+	}
+}
+
+func TestRequired(t *testing.T) {
+	s := New([]string{"--local-domain="}, env(nil))
+	s.String("LOCAL_DOMAIN", "docker", "")
+	if err := s.Validate(); err != nil {
+		t.Fatal("expected no error before the setting is required, got:", err)
+	}
+
+	s.Required("LOCAL_DOMAIN")
+	if err := s.Validate(); err == nil || !strings.Contains(err.Error(), "LOCAL_DOMAIN") {
+		t.Errorf("expected an error for the empty required setting, got %v", err)
+	}
+}
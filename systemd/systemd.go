@@ -5,9 +5,14 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"text/template"
+	"time"
 
 	"github.com/gliderlabs/resolvable/resolver"
+	"github.com/gliderlabs/resolvable/settings"
 
 	"github.com/coreos/go-systemd/daemon"
 	"github.com/coreos/go-systemd/dbus"
@@ -29,21 +34,30 @@ type SystemdConfig struct {
 	destPath     string
 	services     []service
 	written      map[string][]string
+	stopWatch    chan struct{}
+	data         templateArgs
+	generated    *templateArgs
 }
 
+const statusInterval = 30 * time.Second
+
 type templateArgs struct {
 	Address string
+	Port    int
+	// the local domain for container names, e.g. "docker"
+	Domain string
+	// domains forwarded to containers with DNS_RESOLVES
+	Domains []string
+	// names of the Docker bridge interfaces
+	Interfaces []string
 }
 
-func getopt(name, def string) string {
-	if env := os.Getenv(name); env != "" {
-		return env
-	}
-	return def
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
 }
 
 func init() {
-	systemdConf := getopt("SYSTEMD_CONF_PATH", "/tmp/systemd")
+	systemdConf := settings.String("SYSTEMD_CONF_PATH", "/tmp/systemd", "systemd config directory to generate configs in")
 	if _, err := os.Stat(systemdConf); err != nil {
 		log.Printf("systemd: disabled, cannot read %s: %s", systemdConf, err)
 		return
@@ -57,14 +71,36 @@ func init() {
 }
 
 func (r *SystemdConfig) StoreAddress(address string) error {
-	data := templateArgs{address}
+	r.data.Address = address
+	return r.generate()
+}
+
+// UpdateInfo regenerates the configs when the domains, port or interfaces
+// used in the templates change.
+func (r *SystemdConfig) UpdateInfo(info resolver.ResolverInfo) error {
+	r.data = templateArgs{
+		Address:    info.Address,
+		Port:       info.Port,
+		Domain:     info.LocalDomain,
+		Domains:    info.Domains,
+		Interfaces: info.Bridges,
+	}
+	return r.generate()
+}
+
+func (r *SystemdConfig) generate() error {
+	if r.generated != nil && reflect.DeepEqual(*r.generated, r.data) {
+		return nil
+	}
+
+	data := r.data
 
 	for _, s := range r.services {
 		pattern := filepath.Join(r.templatePath, s.dir, s.filepattern)
 
 		log.Printf("systemd: %s: loading config from %s", s.name, pattern)
 
-		templates, err := template.ParseGlob(pattern)
+		templates, err := template.New(s.name).Funcs(templateFuncs).ParseGlob(pattern)
 		if err != nil {
 			log.Println("systemd:", err)
 			continue
@@ -89,15 +125,55 @@ func (r *SystemdConfig) StoreAddress(address string) error {
 			r.written[s.name] = written
 			reload(s.name)
 		} else {
-			log.Println("systemd: %s: no configs written, skipping reload", s.name)
+			log.Printf("systemd: %s: no configs written, skipping reload", s.name)
 		}
 	}
 
-	daemon.SdNotify("READY=1")
+	r.generated = &data
 	return nil
 }
 
+// Watch sends the status of resolvable to systemd, and pings the systemd
+// watchdog when it is enabled and resolvable passes its health check.
+func (r *SystemdConfig) Watch(m resolver.Monitor) {
+	interval, watchdog := watchdogInterval()
+	if watchdog {
+		log.Printf("systemd: watchdog enabled, checking every %s", interval)
+	}
+
+	r.stopWatch = make(chan struct{})
+
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+
+			if err := m.Check(); err != nil {
+				log.Println("systemd: health check failed:", err)
+				daemon.SdNotify("STATUS=health check failed: " + err.Error())
+				continue
+			}
+
+			state := "STATUS=" + m.Status()
+			if watchdog {
+				state += "\nWATCHDOG=1"
+			}
+			daemon.SdNotify(state)
+		}
+	}(r.stopWatch)
+}
+
 func (r *SystemdConfig) Clean() {
+	if r.stopWatch != nil {
+		close(r.stopWatch)
+	}
+
 	daemon.SdNotify("STOPPING=1")
 
 	for service, filenames := range r.written {
@@ -109,6 +185,22 @@ func (r *SystemdConfig) Clean() {
 	}
 }
 
+// watchdogInterval returns how often to ping the watchdog, half the timeout
+// systemd set in WATCHDOG_USEC. If the watchdog is not enabled for this
+// process the status is still updated periodically.
+func watchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return statusInterval, false
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return statusInterval, false
+	}
+
+	return time.Duration(usec) * time.Microsecond / 2, true
+}
+
 func reload(name string) error {
 	conn, err := dbus.New()
 	if err != nil {